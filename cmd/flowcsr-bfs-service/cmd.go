@@ -7,7 +7,14 @@ import (
 	"bigrule/common/logger"
 	"bigrule/pkg/format"
 	"bigrule/services/flowcsr-bfs-service/config"
+	"bigrule/services/flowcsr-bfs-service/middleware"
 	"bigrule/services/flowcsr-bfs-service/middleware/queue"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/history"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
+	"bigrule/services/flowcsr-bfs-service/model/schedule"
+	"bigrule/services/flowcsr-bfs-service/model/template"
 	"bigrule/services/flowcsr-bfs-service/router"
 	"context"
 	"fmt"
@@ -15,9 +22,9 @@ import (
 	"github.com/micro/go-micro/v2/web"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -53,6 +60,25 @@ func setup() {
 	logger.InitLogger(config.LoggerConfig.Path, config.LoggerConfig.Level, config.LoggerConfig.Stdout)
 	//3. 初始化数据库链接
 	db.DBSetUp(config.DbMysqlConfig.Addr, config.DbMysqlConfig.Loglevel)
+	//4. 建表
+	if err := journal.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
+	if err := audit.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
+	if err := ruleid.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
+	if err := history.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
+	if err := template.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
+	if err := schedule.Migrate(); err != nil {
+		logger.Fatal("建表失败: ", err)
+	}
 
 	usageStr := `starting api server`
 	logger.Info(usageStr)
@@ -68,17 +94,17 @@ func run() error {
 	router.RouterSetup()
 	//etcd setup
 	etcd.Setup()
-	// server init
-	srv := &http.Server{
-		Addr:    config.ApplicationConfig.Host + ":" + config.ApplicationConfig.Port,
-		Handler: global.GinEngine,
-	}
+	//处理上次异常退出遗留的写操作，完成后再接受请求
+	journal.Recover(time.Now())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-
+	//执行到期的定时操作
+	go schedule.Run(ctx)
+	stopped := make(chan struct{})
 	go func() {
-		//注册服务
+		defer close(stopped)
+		//注册服务，信号由下方统一处理，ctx取消后注销并停止监听
 		microService := web.NewService(
 			web.Name("repo-bfs-service"),
 			//web.RegisterTTL(time.Second*30),//设置注册服务的过期时间
@@ -86,6 +112,8 @@ func run() error {
 			web.Address(config.ApplicationConfig.Host+":"+config.ApplicationConfig.Port),
 			web.Handler(global.GinEngine),
 			web.Registry(global.EtcdReg),
+			web.Context(ctx),
+			web.HandleSignal(false),
 		)
 		if err := microService.Run(); err != nil {
 			logger.Fatal("listen: ", err)
@@ -98,14 +126,22 @@ func run() error {
 	fmt.Printf("-  Local:   http://localhost:%s/ \r\n", config.ApplicationConfig.Port)
 	fmt.Printf("-  Network: http://%s:%s/ \r\n", format.GetLocaHonst(), config.ApplicationConfig.Port)
 	fmt.Printf("%s Enter Control + C Shutdown Server \r\n", format.GetCurrentTimeStr())
-	// 等待中断信号以优雅地关闭服务器（设置 5 秒的超时时间）
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
+	// 等待中断信号以优雅地关闭服务器
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	fmt.Printf("%s Shutdown Server ... \r\n", format.GetCurrentTimeStr())
 
-	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatal("Server Shutdown:", err)
+	// 1.不再接受新的写操作
+	middleware.StopWrites()
+	// 2.从etcd注销并停止监听
+	cancel()
+	<-stopped
+	// 3.等待进行中的写操作提交或撤销，超时后写操作撤销暂存区，仍未结束的由下次启动时恢复
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), config.ApplicationConfig.ShutdownTimeout)
+	defer drainCancel()
+	if unfinished := middleware.DrainWrites(drainCtx); unfinished != 0 {
+		logger.Error(fmt.Sprintf("%d个写操作超时未结束，下次启动时恢复", unfinished))
 	}
 	logger.Info("Server exiting")
 
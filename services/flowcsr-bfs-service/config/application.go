@@ -1,18 +1,26 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"github.com/spf13/viper"
+	"time"
+)
 
 type Application struct {
-	Host          string
-	Port          string
-	Mode          string
+	Host            string
+	Port            string
+	Mode            string
+	ShutdownTimeout time.Duration // 关闭服务时等待写操作结束的时间
+	JwtSecret       string        // 认证服务签发token的密钥，用于校验调用方身份
 }
 
 func InitApplication(cfg *viper.Viper) *Application {
+	cfg.SetDefault("shutdown_timeout", 30)
 	return &Application{
-		Host:          cfg.GetString("host"),
-		Port:          cfg.GetString("port"),
-		Mode:          cfg.GetString("mode"),
+		Host:            cfg.GetString("host"),
+		Port:            cfg.GetString("port"),
+		Mode:            cfg.GetString("mode"),
+		ShutdownTimeout: time.Duration(cfg.GetInt("shutdown_timeout")) * time.Second,
+		JwtSecret:       cfg.GetString("jwt_secret"),
 	}
 }
 
@@ -0,0 +1,39 @@
+package config
+
+import "github.com/spf13/viper"
+
+// Rule 规则属性取值范围，上游未约定，未配置的项使用默认值
+type Rule struct {
+	MinPriority int
+	MaxPriority int
+	Status      []int
+	Enabled     int // 启用状态，其他状态的规则不参与本地匹配
+	MaxReserve  int // 规则id预留的最长时间，秒
+}
+
+// DefaultRule 默认取值：优先级0-100，状态0停用、1启用，规则id最长预留1天
+func DefaultRule() *Rule {
+	return &Rule{MinPriority: 0, MaxPriority: 100, Status: []int{0, 1}, Enabled: 1, MaxReserve: 86400}
+}
+
+func InitRule(cfg *viper.Viper) *Rule {
+	rule := DefaultRule()
+	if cfg.IsSet("min_priority") {
+		rule.MinPriority = cfg.GetInt("min_priority")
+	}
+	if cfg.IsSet("max_priority") {
+		rule.MaxPriority = cfg.GetInt("max_priority")
+	}
+	if cfg.IsSet("status") {
+		rule.Status = cfg.GetIntSlice("status")
+	}
+	if cfg.IsSet("enabled_status") {
+		rule.Enabled = cfg.GetInt("enabled_status")
+	}
+	if cfg.IsSet("max_reserve_ttl") {
+		rule.MaxReserve = cfg.GetInt("max_reserve_ttl")
+	}
+	return rule
+}
+
+var RuleConfig = DefaultRule()
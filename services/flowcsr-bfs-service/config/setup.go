@@ -25,6 +25,9 @@ var cfgEtcd *viper.Viper
 // user config
 var cfgUser *viper.Viper
 
+// rule config
+var cfgRule *viper.Viper
+
 //setup config
 func Setup(path string) {
 	viper.SetConfigFile(path)
@@ -67,5 +70,9 @@ func Setup(path string) {
 		panic("No found bigrule.repo-bfs-service.repo-user in the configuration")
 	}
 	UserConfig = InitUser(cfgUser)
+	//rule，可选，未配置时使用默认取值
+	if cfgRule = viper.Sub("bigrule.repo-bfs-service.rule"); cfgRule != nil {
+		RuleConfig = InitRule(cfgRule)
+	}
 	//......
 }
@@ -0,0 +1,84 @@
+package audits
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// 单页最多返回的记录数
+const maxPageSize = 500
+
+type AuditQuery struct {
+	UserName  string `json:"user_name"`
+	UserId    int    `json:"user_id"`
+	RepoId    int    `json:"repo_id"`
+	RuleId    int    `json:"rule_id"`
+	TagId     int    `json:"tag_id"`
+	StartTime string `json:"start_time"` // 2006-01-02 15:04:05
+	EndTime   string `json:"end_time"`   // 2006-01-02 15:04:05
+	PageSize  int    `json:"page_size"`
+	PageIndex int    `json:"page_index"`
+}
+
+type AuditQueryRes struct {
+	Total int64         `json:"total"`
+	List  []audit.Audit `json:"list"`
+}
+
+func (This AuditQuery) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("审计记录查询")
+	// 0.权限判断，只返回有权限的规则库、解析规则库、标签表的记录及本人的记录
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	scope := &audit.Scope{
+		UserId: middleware.GetCaller(token).UserId, RepoIds: permissionToken.RepoIds,
+		ParserRepoIds: permissionToken.ParserIds, TagTblIds: permissionToken.TagValIds,
+	}
+	filter := audit.Filter{
+		UserName: This.UserName, UserId: This.UserId, RepoId: This.RepoId, RuleId: This.RuleId, TagId: This.TagId,
+		PageSize: This.PageSize, PageIndex: This.PageIndex, Scope: scope,
+	}
+	if filter.PageSize <= 0 {
+		filter.PageSize = 20
+	}
+	if filter.PageSize > maxPageSize {
+		return ico.Err(2099, fmt.Sprintf("page_size不能大于%d", maxPageSize))
+	}
+	if filter.PageIndex <= 0 {
+		filter.PageIndex = 1
+	}
+	if This.StartTime != "" {
+		if filter.StartTime, err = time.ParseInLocation(timeLayout, This.StartTime, time.Local); err != nil {
+			return ico.Err(2099, "开始时间格式错误")
+		}
+	}
+	if This.EndTime != "" {
+		if filter.EndTime, err = time.ParseInLocation(timeLayout, This.EndTime, time.Local); err != nil {
+			return ico.Err(2099, "结束时间格式错误")
+		}
+	}
+	list, total, err := audit.Query(filter)
+	if err != nil {
+		logger.Error("审计记录查询失败 ", err.Error())
+		return ico.Err(2301, "数据查询失败")
+	}
+	if list == nil {
+		list = []audit.Audit{}
+	}
+	return ico.Succ(AuditQueryRes{Total: total, List: list})
+}
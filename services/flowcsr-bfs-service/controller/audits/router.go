@@ -0,0 +1,18 @@
+package audits
+
+import (
+	"bigrule/common/global"
+	"bigrule/common/ico"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+type AuditRouter struct{}
+
+func (sr AuditRouter) Router(router *gin.Engine) {
+	r := router.Group(fmt.Sprintf("/%s/audit", global.Version)).Use(middleware.AuthToken())
+	{
+		r.POST("/query", ico.Handler(AuditQuery{}))
+	}
+}
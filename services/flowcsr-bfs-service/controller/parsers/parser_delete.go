@@ -5,6 +5,8 @@ import (
 	"bigrule/common/logger"
 	"bigrule/pkg/utils"
 	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
 	"bigrule/services/flowcsr-bfs-service/model/public"
 	"bigrule/services/flowcsr-bfs-service/model/response"
 	"encoding/json"
@@ -17,6 +19,8 @@ import (
 type ParserDelete struct {
 	RepoParserId int   `json:"repo_parser_id"   binding:"required"`
 	ParserIds    []int `json:"parser_ids"       binding:"required"`
+	DryRun       bool  `json:"dry_run"` // 仅返回删除预览
+	Partial      bool  `json:"partial"` // 每条解析规则单独提交，返回每条的结果
 	lineNums     []int
 }
 
@@ -36,37 +40,184 @@ func (This ParserDelete) DoHandle(c *gin.Context) *ico.Result {
 		return ico.Err(2007, "权限不足")
 	}
 	token = permissionToken.Token
+	// 部分成功模式下逐条查询、删除
+	if This.Partial && !This.DryRun {
+		linkRules, code, err := This.GetLinkRules(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		audit.AddRefs(c, audit.KindParserRepo, This.RepoParserId)
+		audit.AddRefs(c, audit.KindParser, This.ParserIds...)
+		for _, linkRule := range linkRules {
+			audit.AddRefs(c, audit.KindRepo, linkRule.RepoId)
+			audit.AddRefs(c, audit.KindRule, linkRule.RuleId)
+		}
+		res := This.HandlePartial(token, linkRules)
+		message := res.ChangeSet.Summary()
+		logger.Info(message)
+		audit.SetMessage(c, message)
+		return ico.Succ(res)
+	}
 	// 1.解析规则查询
+	parsers := []public.ParserInfo{}
 	for _, parserId := range This.ParserIds {
-		parserDataList, code, err := This.GetParserData(token, parserId)
+		parserDataList, code, err := public.GetParsers(token, This.RepoParserId, parserId)
 		if err != nil {
 			return ico.Err(code, err.Error())
 		}
 		// 1.1 多条重复id判断
-		if len(parserDataList.List) > 1 {
+		if len(parserDataList) > 1 {
 			return ico.Err(2301, "有多条重复id规则")
 		}
-		This.lineNums = append(This.lineNums, parserDataList.List[0].LineNum)
+		if len(parserDataList) == 0 {
+			return ico.Err(2301, fmt.Sprintf("解析规则[%d]不存在", parserId))
+		}
+		This.lineNums = append(This.lineNums, parserDataList[0].LineNum)
+		parsers = append(parsers, parserDataList[0])
 	}
-	// 2.解绑解析规则
-	if code, err = This.UnLinkRule(token); err != nil {
-		public.Cancel(token)
+	// 2.查询绑定的识别规则
+	linkRules, code, err := This.GetLinkRules(token)
+	if err != nil {
 		return ico.Err(code, err.Error())
 	}
-	// 3.删除解析规则
-	message := fmt.Sprintf("解析规则删除：[%s]", fmt.Sprint(This.ParserIds))
+	if This.DryRun {
+		return ico.Succ(This.Plan(linkRules))
+	}
+	audit.AddRefs(c, audit.KindParserRepo, This.RepoParserId)
+	audit.AddRefs(c, audit.KindParser, This.ParserIds...)
+	for _, linkRule := range linkRules {
+		audit.AddRefs(c, audit.KindRepo, linkRule.RepoId)
+		audit.AddRefs(c, audit.KindRule, linkRule.RuleId)
+	}
+	// 3.解绑解析规则
+	j := journal.Begin(token, "解析规则删除")
+	for _, linkRule := range linkRules {
+		step := j.Plan("解绑解析规则",
+			journal.Call{Path: "/v1/rule-repo/parser/link-unlink", Params: map[string]interface{}{"repo_id": linkRule.RepoId, "rule_id": linkRule.RuleId, "parser_repo_id": This.RepoParserId}},
+			journal.Call{Path: "/v1/rule-repo/parser/link-unlink", Params: map[string]interface{}{"repo_id": linkRule.RepoId, "rule_id": linkRule.RuleId, "parser_repo_id": This.RepoParserId, "parser_id": linkRule.ParserId}},
+		)
+		if code, err = This.UnLinkParser(token, linkRule.RepoId, linkRule.RuleId); err != nil {
+			j.Abort()
+			return ico.Err(code, err.Error())
+		}
+		j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: linkRule.RepoId, Id: linkRule.RuleId, Fields: []string{"parser"}})
+		j.Done(step)
+	}
+	// 4.删除解析规则
 	for i, ParserId := range This.ParserIds {
+		step := j.Plan("删除解析规则",
+			journal.Call{Path: "/v1/parser-repo/parser/delete", Params: map[string]interface{}{"parser_repo_id": This.RepoParserId, "parser_id": ParserId, "line_num": This.lineNums[i]}},
+		)
+		// 上游没有解析规则新增接口，保留原始数据用于人工恢复
+		j.Keep(step, parsers[i].Raw)
 		if code, err := This.DeleteRule(token, ParserId, This.lineNums[i]); err != nil {
-			public.Cancel(token)
+			j.Abort()
 			return ico.Err(code, err.Error())
 		}
+		j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeParser, RepoId: This.RepoParserId, Id: ParserId, LineNum: This.lineNums[i]})
+		j.Done(step)
 	}
+	message := j.ChangeSet.Summary()
 	logger.Info(message)
-	public.Commit(token, message)
-	return ico.Succ("删除成功")
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(response.WriteRes{Message: "删除成功", ChangeSet: *j.ChangeSet})
+}
+
+// HandlePartial 部分成功模式，每条解析规则连同解绑单独提交
+func (This ParserDelete) HandlePartial(token string, linkRules []LinkRule) *response.PartialRes {
+	res := response.NewPartialRes("解析规则删除")
+	done := []int{}
+	for _, parserId := range This.ParserIds {
+		if utils.IsContainsInt(done, parserId) {
+			continue
+		}
+		done = append(done, parserId)
+		changeSet, code, err := This.deleteOne(token, parserId, linkRules)
+		if err != nil {
+			res.Fail(response.ChangeParser, parserId, code, err.Error())
+			continue
+		}
+		res.Succeed(response.ChangeParser, parserId, changeSet)
+	}
+	return res.Finish()
+}
+
+// deleteOne 解绑并删除单条解析规则，前面的删除会改变行号，执行前重新查询
+func (This ParserDelete) deleteOne(token string, parserId int, linkRules []LinkRule) (changeSet *response.ChangeSet, code int, err error) {
+	// 1.解析规则查询
+	parserDataList, code, err := public.GetParsers(token, This.RepoParserId, parserId)
+	if err != nil {
+		return
+	}
+	if len(parserDataList) > 1 {
+		return nil, 2301, errors.New("有多条重复id规则")
+	}
+	if len(parserDataList) == 0 {
+		return nil, 2301, fmt.Errorf("解析规则[%d]不存在", parserId)
+	}
+	parser := parserDataList[0]
+	j := journal.Begin(token, "解析规则删除")
+	// 2.解绑解析规则
+	for _, linkRule := range linkRules {
+		if linkRule.ParserId != parserId {
+			continue
+		}
+		step := j.Plan("解绑解析规则",
+			journal.Call{Path: "/v1/rule-repo/parser/link-unlink", Params: map[string]interface{}{"repo_id": linkRule.RepoId, "rule_id": linkRule.RuleId, "parser_repo_id": This.RepoParserId}},
+			journal.Call{Path: "/v1/rule-repo/parser/link-unlink", Params: map[string]interface{}{"repo_id": linkRule.RepoId, "rule_id": linkRule.RuleId, "parser_repo_id": This.RepoParserId, "parser_id": linkRule.ParserId}},
+		)
+		if code, err = This.UnLinkParser(token, linkRule.RepoId, linkRule.RuleId); err != nil {
+			j.Abort()
+			return
+		}
+		j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: linkRule.RepoId, Id: linkRule.RuleId, Fields: []string{"parser"}})
+		j.Done(step)
+	}
+	// 3.删除解析规则
+	step := j.Plan("删除解析规则",
+		journal.Call{Path: "/v1/parser-repo/parser/delete", Params: map[string]interface{}{"parser_repo_id": This.RepoParserId, "parser_id": parserId, "line_num": parser.LineNum}},
+	)
+	// 上游没有解析规则新增接口，保留原始数据用于人工恢复
+	j.Keep(step, parser.Raw)
+	if code, err = This.DeleteRule(token, parserId, parser.LineNum); err != nil {
+		j.Abort()
+		return
+	}
+	j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeParser, RepoId: This.RepoParserId, Id: parserId, LineNum: parser.LineNum})
+	j.Done(step)
+	if code, err = j.Commit(); err != nil {
+		j.Abort()
+		return
+	}
+	return j.ChangeSet, 0, nil
+}
+
+// Plan 删除预览
+func (This ParserDelete) Plan(linkRules []LinkRule) response.Plan {
+	plan := response.NewPlan()
+	for _, linkRule := range linkRules {
+		plan.UnlinkParsers = append(plan.UnlinkParsers, response.PlanUnlinkParser{
+			RepoId: linkRule.RepoId, RuleId: linkRule.RuleId, ParserRepoId: This.RepoParserId, ParserId: linkRule.ParserId,
+		})
+	}
+	for i, parserId := range This.ParserIds {
+		plan.DeleteParsers = append(plan.DeleteParsers, response.PlanParser{ParserRepoId: This.RepoParserId, ParserId: parserId, LineNum: This.lineNums[i]})
+	}
+	return plan
 }
 
-func (This ParserDelete) UnLinkRule(token string) (code int, err error) {
+// 绑定待删除解析规则的识别规则
+type LinkRule struct {
+	RepoId   int `json:"repo_id"`
+	RuleId   int `json:"rule_id"`
+	ParserId int `json:"parser_id"`
+}
+
+func (This ParserDelete) GetLinkRules(token string) (linkRules []LinkRule, code int, err error) {
 	// 1.查询绑定的识别规则库
 	repoDataList, code, err := This.GetRepoData(token)
 	if err != nil {
@@ -82,19 +233,11 @@ func (This ParserDelete) UnLinkRule(token string) (code int, err error) {
 	for _, repoId := range repoIds {
 		ruleDataList, code, err := This.GetRuleData(token, repoId)
 		if err != nil {
-			return code, err
+			return linkRules, code, err
 		}
-		ruleIds := []int{}
 		for _, rule := range ruleDataList.List {
 			if utils.IsContainsInt(This.ParserIds, rule.ParserMessage.ParserId) {
-				ruleIds = append(ruleIds, rule.RuleId)
-			}
-		}
-		// 3.解绑解析规则
-		for _, ruleId := range ruleIds {
-			code, err = This.UnLinkParser(token, repoId, ruleId)
-			if err != nil {
-				return code, err
+				linkRules = append(linkRules, LinkRule{RepoId: repoId, RuleId: rule.RuleId, ParserId: rule.ParserMessage.ParserId})
 			}
 		}
 	}
@@ -220,47 +363,6 @@ func (This ParserDelete) UnLinkParser(token string, repoId, ruleId int) (code in
 	return
 }
 
-// 解析规则
-type ParserRes struct {
-	Code    int           `json:"code"`
-	Message string        `json:"message"`
-	Data    ParserListRes `json:"data"`
-}
-
-type ParserListRes struct {
-	List []ParserInfo `json:"list"`
-}
-
-type ParserInfo struct {
-	ParserId int `json:"parser_id"`
-	LineNum  int `json:"line_num"`
-}
-
-func (This ParserDelete) GetParserData(token string, parserId int) (resData ParserListRes, code int, err error) {
-	// 1.整理入参
-	pars := map[string]interface{}{"parser_repo_id": This.RepoParserId, "type": 1, "parser_id": parserId}
-	urlRepo := fmt.Sprintf("http://%s/v1/parser-repo/parser/query", middleware.GetAddr("repo-service"))
-	headers := map[string]string{"X-Access-Token": token}
-	// 2.获取数据
-	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
-	if err != nil {
-		logger.Info("数据查询失败")
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	res := ParserRes{}
-	err = json.Unmarshal(respRepo, &res)
-	if err != nil {
-		logger.Info("数据查询失败", string(respRepo), pars)
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	if res.Code != 200 {
-		logger.Info("数据查询失败")
-		return resData, res.Code, errors.New(res.Message)
-	}
-	resData = res.Data
-	return
-}
-
 func (This *ParserDelete) DeleteRule(token string, parserId, lineNum int) (code int, err error) {
 	// 1.整理入参
 	pars := map[string]interface{}{"parser_repo_id": This.RepoParserId, "parser_id": parserId, "line_num": lineNum}
@@ -11,7 +11,7 @@ import (
 type ParserRouter struct{}
 
 func (sr ParserRouter) Router(router *gin.Engine) {
-	r := router.Group(fmt.Sprintf("/%s/parsers", global.Version)).Use(middleware.AuthToken())
+	r := router.Group(fmt.Sprintf("/%s/parsers", global.Version)).Use(middleware.AuthToken(), middleware.Audit())
 	{
 		r.POST("/delete", ico.Handler(ParserDelete{}))
 	}
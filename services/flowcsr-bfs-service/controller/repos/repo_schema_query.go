@@ -0,0 +1,49 @@
+package repos
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// RepoSchemaQuery 规则库的规则体属性及维度，供调用方组装规则新增、修改入参
+type RepoSchemaQuery struct {
+	RepoId int `json:"repo_id" binding:"required"`
+}
+
+type RepoSchemaRes struct {
+	RepoId     int            `json:"repo_id"`
+	Name       string         `json:"name"`
+	Attributes []SchemaAttr   `json:"attributes"` // 规则体可用的key，为空时不限制
+	Dimensions []DimensionRes `json:"dimensions"`
+}
+
+type SchemaAttr struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (This RepoSchemaQuery) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则库结构查询")
+	repo, code, err := public.GetRepo(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	res := RepoSchemaRes{
+		RepoId: repo.RepoId, Name: repo.Name, Attributes: []SchemaAttr{}, Dimensions: []DimensionRes{},
+	}
+	for _, attr := range repo.AttrMsg {
+		res.Attributes = append(res.Attributes, SchemaAttr{Id: attr.Id, Name: attr.Name})
+	}
+	for _, dim := range repo.DimensionMsg {
+		res.Dimensions = append(res.Dimensions, DimensionRes{Id: dim.DimensionId, Name: dim.Name})
+	}
+	return ico.Succ(res)
+}
@@ -16,5 +16,6 @@ func (sr RepoRouter) Router(router *gin.Engine) {
 		r.POST("/list/query", ico.Handler(RepoQuery{}))
 		r.POST("/attributes/list/query", ico.Handler(RepoAttrQuery{}))
 		r.POST("/dimensions/list/query", ico.Handler(RepoDimQuery{}))
+		r.POST("/schema/query", ico.Handler(RepoSchemaQuery{}))
 	}
 }
@@ -11,12 +11,35 @@ import (
 type RuleRouter struct{}
 
 func (sr RuleRouter) Router(router *gin.Engine) {
-	r := router.Group(fmt.Sprintf("/%s/rules", global.Version)).Use(middleware.AuthToken())
+	r := router.Group(fmt.Sprintf("/%s/rules", global.Version)).Use(middleware.AuthToken(), middleware.Audit())
 	{
 		r.POST("/add-batch", ico.Handler(RuleAdd{}))
+		r.POST("/import", ico.Handler(RuleImport{}))
 		r.POST("/query", ico.Handler(RuleQuery{}))
+		r.POST("/list", ico.Handler(RuleList{}))
 		r.POST("/attributes/query", ico.Handler(RuleAttrQuery{}))
 		r.POST("/regex/query", ico.Handler(RuleRegexQuery{}))
+		r.POST("/search", ico.Handler(RuleSearch{}))
 		r.POST("/delete", ico.Handler(RuleDelete{}))
+		r.POST("/update-batch", ico.Handler(RuleUpdate{}))
+		r.POST("/status", ico.Handler(RuleStatus{}))
+		r.POST("/ids/reserve", ico.Handler(RuleIdReserve{}))
+		r.POST("/duplicates/query", ico.Handler(DuplicateQuery{}))
+		r.POST("/duplicates/repair", ico.Handler(DuplicateRepair{}))
+		r.POST("/test", ico.Handler(RuleTest{}))
+		r.POST("/regression", ico.Handler(RuleRegression{}))
+		r.POST("/overlap", ico.Handler(RuleOverlap{}))
+		r.POST("/copy", ico.Handler(RuleCopy{}))
+		r.POST("/move", ico.Handler(RuleMove{}))
+		r.POST("/diff", ico.Handler(RuleDiff{}))
+		r.POST("/history", ico.Handler(RuleHistory{}))
+		r.POST("/templates/save", ico.Handler(TemplateSave{}))
+		r.POST("/templates/list", ico.Handler(TemplateList{}))
+		r.POST("/templates/delete", ico.Handler(TemplateDelete{}))
+		r.POST("/templates/preview", ico.Handler(TemplatePreview{}))
+		r.POST("/templates/submit", ico.Handler(TemplateSubmit{}))
+		r.POST("/schedules/create", ico.Handler(ScheduleCreate{}))
+		r.POST("/schedules/list", ico.Handler(ScheduleList{}))
+		r.POST("/schedules/cancel", ico.Handler(ScheduleCancel{}))
 	}
 }
@@ -5,8 +5,11 @@ import (
 	"bigrule/common/logger"
 	"bigrule/pkg/utils"
 	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
 	"bigrule/services/flowcsr-bfs-service/model/public"
 	"bigrule/services/flowcsr-bfs-service/model/response"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,13 +18,36 @@ import (
 )
 
 type RuleAdd struct {
-	RepoId   int    `json:"repo_id"      binding:"required"`
-	RuleList []Rule `json:"rule_list"    binding:"required"`
-	TagVal   TagVal `json:"tag"          binding:"required"`
+	RepoId   int     `json:"repo_id"      binding:"required"`
+	RuleList []Rule  `json:"rule_list"    binding:"required"`
+	TagVal   *TagVal `json:"tag"` // 需新增的标签，已存在的不再新增；只关联已有标签时可不传
+	// 为true时写入前做样例回归，新增规则引入的失败项会阻止写入
+	Regression bool `json:"regression"`
+	// 为true时忽略入参中的规则id，按规则库预留新id
+	AutoId bool `json:"auto_id"`
+	// 为true时每条规则单独提交，返回每条规则的结果
+	Partial bool `json:"partial"`
+}
+
+type RuleAddRes struct {
+	response.WriteRes
+	Assigned []IdAssign `json:"assigned,omitempty"`
+}
+
+type RuleAddPartialRes struct {
+	response.PartialRes
+	Assigned []IdAssign `json:"assigned,omitempty"`
+}
+
+// IdAssign 自动分配的规则id，Index为规则在入参中的下标
+type IdAssign struct {
+	Index  int `json:"index"`
+	Id     int `json:"id"`
+	RuleId int `json:"rule_id"`
 }
 
 type Rule struct {
-	Id         int                `json:"id"          binding:"required"`
+	Id         int                `json:"id"` // auto_id时可不传
 	Attr       response.Attribute `json:"attr"        binding:"required"`
 	Pattern    map[string]string  `json:"pattern"     binding:"required"`
 	Dimensions []DimensionAddRule `json:"dimensions"`
@@ -46,9 +72,14 @@ func (This RuleAdd) DoHandle(c *gin.Context) *ico.Result {
 	if err := c.ShouldBindJSON(&This); err != nil {
 		return ico.Err(2099, "", err.Error())
 	}
+	logger.Info("规则新增")
+	return This.Handle(c)
+}
+
+// Handle 先新增标签再新增规则，文件导入等入口整理好入参后复用
+func (This RuleAdd) Handle(c *gin.Context) *ico.Result {
 	token := c.GetHeader("X-Access-Token")
 	token = strings.Split(token, ";")[0]
-	logger.Info("规则新增")
 	// 0.权限判断
 	permissionToken, code, err := middleware.GetPermission(token)
 	if err != nil {
@@ -57,38 +88,376 @@ func (This RuleAdd) DoHandle(c *gin.Context) *ico.Result {
 	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
 		return ico.Err(2007, "权限不足")
 	}
-	if !utils.IsContainsInt(permissionToken.TagValIds, This.TagVal.TagValTblId) {
+	if This.TagVal != nil && !utils.IsContainsInt(permissionToken.TagValIds, This.TagVal.TagValTblId) {
 		return ico.Err(2007, "权限不足")
 	}
-	message := fmt.Sprint("批量规则增加： ")
-	// 1.新增标签
-	if code, err := This.AddTag(token); err != nil {
-		public.Cancel(token)
+	// 0.1 自动分配规则id
+	assigned := []IdAssign{}
+	if This.AutoId {
+		ruleIds, _, code, err := ReserveRuleIds(token, This.RepoId, len(This.RuleList), ruleid.DefaultTTL)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		defer ruleid.Release(This.RepoId, ruleIds)
+		for i := range This.RuleList {
+			assigned = append(assigned, IdAssign{Index: i, Id: This.RuleList[i].Id, RuleId: ruleIds[i]})
+			This.RuleList[i].Id = ruleIds[i]
+		}
+	}
+	// 0.2 写入前校验，有错误时不写入任何标签、规则；部分成功模式下只跳过有错误的规则
+	newTags, ruleErrors, code, err := This.Validate(token)
+	if err != nil {
 		return ico.Err(code, err.Error())
 	}
-	messageTag := " 增加标签：["
-	for _, tag := range This.TagVal.Tag {
-		messageTag += fmt.Sprint(tag.Id) + " "
+	if len(ruleErrors) != 0 && !This.Partial {
+		res := ico.Err(2098, "规则校验失败")
+		res.Data = ruleErrors
+		return res
+	}
+	// 0.3 样例回归
+	report := RegressReport{}
+	if This.Regression {
+		report, code, err = This.Regress(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		if !report.IsEmpty() && !This.Partial {
+			res := ico.Err(2097, "样例回归失败")
+			res.Data = report
+			return res
+		}
+	}
+	ruleIds := []int{}
+	for _, rule := range This.RuleList {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	audit.AddRefs(c, audit.KindRule, ruleIds...)
+	for _, tag := range newTags {
+		audit.AddRefs(c, audit.KindTag, tag.Id)
+	}
+	if This.Partial {
+		res := This.HandlePartial(token, newTags, ruleErrors, report)
+		message := res.ChangeSet.Summary()
+		logger.Info(message)
+		audit.SetMessage(c, message)
+		return ico.Succ(RuleAddPartialRes{PartialRes: *res, Assigned: assigned})
+	}
+	j := journal.Begin(token, "批量规则增加")
+	// 1.新增标签，只新增不存在的
+	if code, err := This.addTags(token, j, newTags); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
 	}
-	message += messageTag + "]"
 	// 2.新增规则
-	if code, err := This.AddRule(token); err != nil {
-		public.Cancel(token)
+	if code, err := This.addRules(token, j, This.RuleList); err != nil {
+		j.Abort()
 		return ico.Err(code, err.Error())
 	}
-	messageRule := " 增加规则：["
+	message := j.ChangeSet.Summary()
+	logger.Info(message)
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(RuleAddRes{WriteRes: response.WriteRes{Message: "新增成功", ChangeSet: *j.ChangeSet}, Assigned: assigned})
+}
+
+// HandlePartial 部分成功模式，每条规则连同其首次关联的新标签单独提交；没有成功的规则关联的新标签逐个单独提交
+func (This RuleAdd) HandlePartial(token string, newTags []Tag, ruleErrors []RuleError, report RegressReport) *response.PartialRes {
+	res := response.NewPartialRes("批量规则增加")
+	invalid := map[int]string{}
+	for _, ruleError := range ruleErrors {
+		invalid[ruleError.Index] = strings.Join(ruleError.Errors, "；")
+	}
+	regressed := report.RuleIds()
+	pending := map[int]Tag{}
+	for _, tag := range newTags {
+		pending[tag.Id] = tag
+	}
+	commit := func(tags []Tag, rules []Rule) (changeSet *response.ChangeSet, code int, err error) {
+		j := journal.Begin(token, "批量规则增加")
+		code, err = This.addTags(token, j, tags)
+		if err == nil {
+			code, err = This.addRules(token, j, rules)
+		}
+		if err == nil {
+			code, err = j.Commit()
+		}
+		if err != nil {
+			j.Abort()
+		}
+		return j.ChangeSet, code, err
+	}
+	for i, rule := range This.RuleList {
+		if message, ok := invalid[i]; ok {
+			res.Fail(response.ChangeRule, rule.Id, 2098, message)
+			continue
+		}
+		if utils.IsContainsInt(regressed, rule.Id) {
+			res.Fail(response.ChangeRule, rule.Id, 2097, "样例回归失败")
+			continue
+		}
+		tags := []Tag{}
+		for _, dim := range rule.Dimensions {
+			if tag, ok := pending[dim.ValueId]; ok {
+				tags = append(tags, tag)
+				delete(pending, tag.Id)
+			}
+		}
+		changeSet, code, err := commit(tags, []Rule{rule})
+		if err != nil {
+			// 失败时标签已回滚，留给后续规则
+			for _, tag := range tags {
+				pending[tag.Id] = tag
+			}
+			res.Fail(response.ChangeRule, rule.Id, code, err.Error())
+			continue
+		}
+		res.Succeed(response.ChangeRule, rule.Id, changeSet)
+	}
+	// 没有成功的规则关联的新标签单独新增
+	for _, tag := range newTags {
+		if _, ok := pending[tag.Id]; !ok {
+			continue
+		}
+		changeSet, code, err := commit([]Tag{tag}, nil)
+		if err != nil {
+			res.Fail(response.ChangeTag, tag.Id, code, err.Error())
+			continue
+		}
+		res.Succeed(response.ChangeTag, tag.Id, changeSet)
+	}
+	return res.Finish()
+}
+
+// addTags 新增标签，新增后按行号登记补偿
+func (This RuleAdd) addTags(token string, j *journal.Journal, tags []Tag) (code int, err error) {
+	if len(tags) == 0 {
+		return
+	}
+	tagVal := TagVal{TagValTblId: This.TagVal.TagValTblId, Tag: tags}
+	tagIds := []int{}
+	for _, tag := range tags {
+		tagIds = append(tagIds, tag.Id)
+	}
+	step := j.Plan("新增标签", journal.Call{Path: "/v1/tag/add", Params: tagVal})
+	if code, err = This.AddTag(token, tagVal); err != nil {
+		return
+	}
+	// 新增后才有行号，据此登记补偿
+	if tagDataList, _, err := This.GetTagData(token, tagVal.TagValTblId, tagIds); err == nil {
+		deleteTag := DeleteTag{TagValTblId: tagVal.TagValTblId}
+		for _, tagData := range tagDataList.List {
+			deleteTag.TagvalIds = append(deleteTag.TagvalIds, tagData.Id)
+			deleteTag.LineNums = append(deleteTag.LineNums, tagData.LineNum)
+		}
+		j.SetCompensate(step, journal.Call{Path: "/v1/tag/delete", Params: deleteTag})
+	}
+	for _, tag := range tags {
+		j.ChangeSet.Add(response.ChangeItem{Type: response.ChangeTag, RepoId: tagVal.TagValTblId, Id: tag.Id})
+	}
+	j.Done(step)
+	return
+}
+
+// addRules 新增规则，新增后按行号登记补偿
+func (This RuleAdd) addRules(token string, j *journal.Journal, rules []Rule) (code int, err error) {
+	if len(rules) == 0 {
+		return
+	}
+	ruleIds := []int{}
+	for _, rule := range rules {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	step := j.Plan("新增规则", journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": This.RepoId, "messages": rules}})
+	if code, err = This.AddRule(token, rules); err != nil {
+		return
+	}
+	lineNums := map[int]int{}
+	if ruleDataList, _, err := public.GetRules(token, This.RepoId, ruleIds); err == nil {
+		compensate := []journal.Call{}
+		for _, ruleData := range ruleDataList.List {
+			compensate = append(compensate, journal.Call{Path: "/v1/rule-repo/rule/delete",
+				Params: map[string]interface{}{"repo_id": This.RepoId, "rule_id": ruleData.RuleId, "line_num": ruleData.LineNum}})
+			lineNums[ruleData.RuleId] = ruleData.LineNum
+		}
+		j.SetCompensate(step, compensate...)
+	}
+	for _, rule := range rules {
+		j.ChangeSet.Add(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: rule.Id, LineNum: lineNums[rule.Id]})
+	}
+	j.Done(step)
+	return
+}
+
+// Messages 转换为规则新增入参
+func (This RuleAdd) Messages() (messages []public.RuleMessage) {
 	for _, rule := range This.RuleList {
-		messageRule += fmt.Sprint(rule.Id) + " "
+		message := public.RuleMessage{Id: rule.Id, Attr: rule.Attr, Pattern: rule.Pattern, Dimensions: []public.RuleDimensionAdd{}}
+		for _, dim := range rule.Dimensions {
+			message.Dimensions = append(message.Dimensions, public.RuleDimensionAdd{DimensionId: dim.DimensionId, ValueId: dim.ValueId})
+		}
+		messages = append(messages, message)
 	}
-	message += messageRule + "]"
-	logger.Info(message)
-	public.Commit(token, message)
-	return ico.Succ("新增成功")
+	return
+}
+
+// NewRules 规则新增入参转换为批量新增的规则
+func NewRules(messages []public.RuleMessage) (rules []Rule) {
+	for _, message := range messages {
+		rule := Rule{Id: message.Id, Attr: message.Attr, Pattern: message.Pattern, Dimensions: []DimensionAddRule{}}
+		for _, dim := range message.Dimensions {
+			rule.Dimensions = append(rule.Dimensions, DimensionAddRule{DimensionId: dim.DimensionId, ValueId: dim.ValueId})
+		}
+		rules = append(rules, rule)
+	}
+	return
 }
 
-func (This *RuleAdd) AddTag(token string) (code int, err error) {
+// Validate 写入前校验，返回需新增的标签及每条规则的错误
+func (This RuleAdd) Validate(token string) (newTags []Tag, ruleErrors []RuleError, code int, err error) {
+	// 1.规则库维度
+	repo, code, err := public.GetRepo(token, This.RepoId)
+	if err != nil {
+		return
+	}
+	// 2.库中已有的同id规则
+	ruleIds := []int{}
+	for _, rule := range This.RuleList {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	ruleDataList, code, err := public.GetRules(token, This.RepoId, ruleIds)
+	if err != nil {
+		return
+	}
+	validator := RuleValidator{Repo: repo}
+	for _, ruleData := range ruleDataList.List {
+		validator.Existing = append(validator.Existing, ruleData.RuleId)
+	}
+	// 2.1 其他调用方预留中的id
+	if validator.Reserved, err = ruleid.ReservedByOthers(This.RepoId, ruleIds, ReservationOwner(token)); err != nil {
+		logger.Error("规则id预留查询失败 ", err.Error())
+		return nil, nil, 2301, err
+	}
+	ruleErrors = validator.Validate(This.Messages())
+	// 3.规则关联的标签需已存在或在本次新增
+	newTags, tagErrors, code, err := This.CheckTags(token)
+	if err != nil {
+		return
+	}
+	for _, tagError := range tagErrors {
+		for _, message := range tagError.Errors {
+			ruleErrors = addRuleError(ruleErrors, tagError.Index, tagError.Id, message)
+		}
+	}
+	return
+}
+
+// CheckTags 查询已有标签，返回需新增的标签及规则关联标签不存在的错误；入参标签与已有标签同id不同名时报错，
+// 入参中重复的标签只新增一次
+func (This RuleAdd) CheckTags(token string) (newTags []Tag, ruleErrors []RuleError, code int, err error) {
+	// 1.入参中的标签
+	if This.TagVal != nil && len(This.TagVal.Tag) != 0 {
+		tagIds := []int{}
+		tags := []Tag{}
+		for _, tag := range This.TagVal.Tag {
+			if i := indexOfTag(tags, tag.Id); i >= 0 {
+				if tags[i].Value != tag.Value {
+					return nil, nil, 2099, fmt.Errorf("标签[%d]重复且名称不同", tag.Id)
+				}
+				continue
+			}
+			tags = append(tags, tag)
+			tagIds = append(tagIds, tag.Id)
+		}
+		tagDataList, code, err := This.GetTagData(token, This.TagVal.TagValTblId, tagIds)
+		if err != nil {
+			return nil, nil, code, err
+		}
+		names := map[int]string{}
+		for _, tagData := range tagDataList.List {
+			names[tagData.Id] = tagData.Name
+		}
+		for _, tag := range tags {
+			name, ok := names[tag.Id]
+			if !ok {
+				newTags = append(newTags, tag)
+				continue
+			}
+			if name != tag.Value {
+				return nil, nil, 2099, fmt.Errorf("标签[%d]已存在，名称为%s", tag.Id, name)
+			}
+		}
+	}
+	// 2.规则维度对应的标签表中查询关联的标签
+	dimRes, code, err := middleware.GetDimData(token, []int{This.RepoId})
+	if err != nil {
+		return
+	}
+	tblIds := map[int]int{}
+	for _, dim := range dimRes.List {
+		if dim.RepoId == This.RepoId {
+			tblIds[dim.DimensionId] = dim.TagValMsg.Id
+		}
+	}
+	wanted := map[int][]int{}
+	for _, rule := range This.RuleList {
+		for _, dim := range rule.Dimensions {
+			if tblId, ok := tblIds[dim.DimensionId]; ok {
+				wanted[tblId] = append(wanted[tblId], dim.ValueId)
+			}
+		}
+	}
+	found := map[int]map[int]bool{}
+	for tblId, tagIds := range wanted {
+		tagDataList, code, err := This.GetTagData(token, tblId, tagIds)
+		if err != nil {
+			return nil, nil, code, err
+		}
+		found[tblId] = map[int]bool{}
+		for _, tagData := range tagDataList.List {
+			found[tblId][tagData.Id] = true
+		}
+	}
+	if This.TagVal != nil {
+		if _, ok := found[This.TagVal.TagValTblId]; !ok {
+			found[This.TagVal.TagValTblId] = map[int]bool{}
+		}
+		for _, tag := range newTags {
+			found[This.TagVal.TagValTblId][tag.Id] = true
+		}
+	}
+	// 3.维度不属于规则库的由规则校验报错
+	for i, rule := range This.RuleList {
+		for _, dim := range rule.Dimensions {
+			if tblId, ok := tblIds[dim.DimensionId]; ok && !found[tblId][dim.ValueId] {
+				ruleErrors = addRuleError(ruleErrors, i, rule.Id, fmt.Sprintf("维度[%d]的标签[%d]不存在", dim.DimensionId, dim.ValueId))
+			}
+		}
+	}
+	return
+}
+
+// Regress 合入新增规则后做样例回归，只返回新增规则引入的失败项
+func (This RuleAdd) Regress(token string) (report RegressReport, code int, err error) {
+	ruleDataList, code, err := public.GetRepoRules(token, This.RepoId)
+	if err != nil {
+		return
+	}
+	rules := ruleDataList.List
+	base := Regress(rules)
+	for _, message := range This.Messages() {
+		rules = append(rules, message.Info())
+	}
+	report = Regress(rules).Since(base)
+	return
+}
+
+func (This *RuleAdd) AddTag(token string, tagVal TagVal) (code int, err error) {
 	// 1.整理入参
-	pars := This.TagVal
+	pars := tagVal
 	urlRepo := fmt.Sprintf("http://%s/v1/tag/add", middleware.GetAddr("repo-service"))
 	headers := map[string]string{"X-Access-Token": token}
 	// 2.获取数据
@@ -110,9 +479,9 @@ func (This *RuleAdd) AddTag(token string) (code int, err error) {
 	return
 }
 
-func (This *RuleAdd) AddRule(token string) (code int, err error) {
+func (This *RuleAdd) AddRule(token string, rules []Rule) (code int, err error) {
 	// 1.整理入参
-	pars := map[string]interface{}{"repo_id": This.RepoId, "messages": This.RuleList}
+	pars := map[string]interface{}{"repo_id": This.RepoId, "messages": rules}
 	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/rule/batchadd", middleware.GetAddr("repo-service"))
 	headers := map[string]string{"X-Access-Token": token}
 	// 2.获取数据
@@ -133,3 +502,37 @@ func (This *RuleAdd) AddRule(token string) (code int, err error) {
 	}
 	return
 }
+
+func (This *RuleAdd) GetTagData(token string, tagValTblId int, tagIds []int) (resData TagData, code int, err error) {
+	// 1.整理入参
+	pars := map[string]interface{}{"tagval_tbl_id": tagValTblId, "type": 1, "tagval_ids": tagIds}
+	urlRepo := fmt.Sprintf("http://%s/v1/tag/query", middleware.GetAddr("repo-service"))
+	headers := map[string]string{"X-Access-Token": token}
+	// 2.获取数据
+	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	res := TagRes{}
+	err = json.Unmarshal(respRepo, &res)
+	if err != nil {
+		logger.Info("数据查询失败", string(respRepo), pars)
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败")
+		return resData, res.Code, errors.New(res.Message)
+	}
+	resData = res.Data
+	return
+}
+
+func indexOfTag(tags []Tag, id int) int {
+	for i, tag := range tags {
+		if tag.Id == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,266 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// RuleCopy 规则复制到其他规则库，维度按名称对应
+type RuleCopy struct {
+	SrcRepoId int   `json:"src_repo_id"  binding:"required"`
+	DstRepoId int   `json:"dst_repo_id"  binding:"required"`
+	RuleIds   []int `json:"rule_ids"     binding:"required,min=1"`
+	Reassign  bool  `json:"reassign"` // id在目标库中已存在时重新分配id
+}
+
+// RuleMove 规则移动，复制后删除源规则
+type RuleMove struct {
+	RuleCopy
+}
+
+type RuleCopyRes struct {
+	response.WriteRes
+	IdMap map[int]int `json:"id_map"` // 源规则id -> 目标规则id
+}
+
+func (This RuleCopy) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	logger.Info("规则复制")
+	return This.handle(c, false)
+}
+
+func (This RuleMove) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	logger.Info("规则移动")
+	return This.handle(c, true)
+}
+
+func (This RuleCopy) handle(c *gin.Context, move bool) *ico.Result {
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	if This.SrcRepoId == This.DstRepoId {
+		return ico.Err(2099, "源规则库与目标规则库相同")
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.SrcRepoId) || !utils.IsContainsInt(permissionToken.RepoIds, This.DstRepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 1.源规则，入参去重，规则需存在且只有一行
+	ruleIds := []int{}
+	for _, ruleId := range This.RuleIds {
+		if !utils.IsContainsInt(ruleIds, ruleId) {
+			ruleIds = append(ruleIds, ruleId)
+		}
+	}
+	This.RuleIds = ruleIds
+	ruleDataList, code, err := public.GetRules(token, This.SrcRepoId, This.RuleIds)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	lines := map[int]int{}
+	for _, ruleData := range ruleDataList.List {
+		lines[ruleData.RuleId]++
+	}
+	for _, ruleId := range This.RuleIds {
+		if lines[ruleId] == 0 {
+			return ico.Err(2099, fmt.Sprintf("规则[%d]不存在", ruleId))
+		}
+		if lines[ruleId] > 1 {
+			return ico.Err(2098, fmt.Sprintf("规则[%d]在源规则库中有多行，请先修复重复id", ruleId))
+		}
+	}
+	// 2.维度映射
+	dimMap, code, err := This.DimensionMap(token, ruleDataList.List)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	// 3.目标库id冲突
+	dstDataList, code, err := public.GetRepoRules(token, This.DstRepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	reserved, err := ruleid.Others(This.DstRepoId, ReservationOwner(token))
+	if err != nil {
+		logger.Error("规则id预留查询失败 ", err.Error())
+		return ico.Err(2301, err.Error())
+	}
+	idMap, err := This.IdMap(ruleDataList.List, dstDataList.List, reserved)
+	if err != nil {
+		return ico.Err(2098, err.Error())
+	}
+	messages := []public.RuleMessage{}
+	for _, ruleData := range ruleDataList.List {
+		message := ruleData.Message()
+		message.Id = idMap[ruleData.RuleId]
+		dims := []public.RuleDimensionAdd{}
+		for _, dim := range message.Dimensions {
+			dims = append(dims, public.RuleDimensionAdd{DimensionId: dimMap[dim.DimensionId], ValueId: dim.ValueId})
+		}
+		message.Dimensions = dims
+		messages = append(messages, message)
+	}
+	// 3.1 按目标库校验规则体、属性、维度及标签
+	add := RuleAdd{RepoId: This.DstRepoId, RuleList: NewRules(messages)}
+	_, ruleErrors, code, err := add.Validate(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if len(ruleErrors) != 0 {
+		res := ico.Err(2098, "规则校验失败")
+		res.Data = ruleErrors
+		return res
+	}
+	dstIds := []int{}
+	for _, message := range messages {
+		dstIds = append(dstIds, message.Id)
+	}
+	audit.AddRefs(c, audit.KindRepo, This.SrcRepoId, This.DstRepoId)
+	audit.AddRefs(c, audit.KindRule, This.RuleIds...)
+	operation := "规则复制"
+	if move {
+		operation = "规则移动"
+	}
+	j := journal.Begin(token, operation)
+	j.Snapshot(This.SrcRepoId, ruleDataList.List...)
+	// 4.目标库新增规则
+	forward := journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": This.DstRepoId, "messages": messages}}
+	step := j.Plan("新增规则", forward)
+	if code, err := journal.Exec(token, forward); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	lineNums := map[int]int{}
+	if addDataList, _, err := public.GetRules(token, This.DstRepoId, dstIds); err == nil {
+		compensate := []journal.Call{}
+		for _, ruleData := range addDataList.List {
+			compensate = append(compensate, journal.Call{Path: "/v1/rule-repo/rule/delete",
+				Params: map[string]interface{}{"repo_id": This.DstRepoId, "rule_id": ruleData.RuleId, "line_num": ruleData.LineNum}})
+			lineNums[ruleData.RuleId] = ruleData.LineNum
+		}
+		j.SetCompensate(step, compensate...)
+	}
+	for _, id := range dstIds {
+		j.ChangeSet.Add(response.ChangeItem{Type: response.ChangeRule, RepoId: This.DstRepoId, Id: id, LineNum: lineNums[id]})
+	}
+	j.Done(step)
+	// 5.移动时删除源规则
+	if move {
+		for _, ruleData := range ruleDataList.List {
+			forward := journal.Call{Path: "/v1/rule-repo/rule/delete", Params: map[string]interface{}{"repo_id": This.SrcRepoId, "rule_id": ruleData.RuleId, "line_num": ruleData.LineNum}}
+			step := j.Plan("删除规则", forward,
+				journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": This.SrcRepoId, "messages": []public.RuleMessage{ruleData.Message()}}},
+			)
+			if code, err := journal.Exec(token, forward); err != nil {
+				j.Abort()
+				return ico.Err(code, err.Error())
+			}
+			j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeRule, RepoId: This.SrcRepoId, Id: ruleData.RuleId, LineNum: ruleData.LineNum})
+			j.Done(step)
+		}
+	}
+	message := j.ChangeSet.Summary()
+	logger.Info(message)
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(RuleCopyRes{WriteRes: response.WriteRes{Message: operation + "成功", ChangeSet: *j.ChangeSet}, IdMap: idMap})
+}
+
+// DimensionMap 源库维度id -> 目标库维度id，按维度名称对应，规则用到的维度在目标库中需存在
+func (This RuleCopy) DimensionMap(token string, rules []public.RuleInfo) (dimMap map[int]int, code int, err error) {
+	srcRepo, code, err := public.GetRepo(token, This.SrcRepoId)
+	if err != nil {
+		return
+	}
+	dstRepo, code, err := public.GetRepo(token, This.DstRepoId)
+	if err != nil {
+		return
+	}
+	dstDims := map[string]int{}
+	for _, dim := range dstRepo.DimensionMsg {
+		dstDims[dim.Name] = dim.DimensionId
+	}
+	used := map[int]bool{}
+	for _, rule := range rules {
+		for _, dim := range rule.Dimensions {
+			used[dim.DimensionId] = true
+		}
+	}
+	dimMap = map[int]int{}
+	missing := []string{}
+	for _, dim := range srcRepo.DimensionMsg {
+		dstId, ok := dstDims[dim.Name]
+		if !ok {
+			if used[dim.DimensionId] {
+				missing = append(missing, dim.Name)
+			}
+			continue
+		}
+		dimMap[dim.DimensionId] = dstId
+	}
+	if len(missing) != 0 {
+		return dimMap, 2098, fmt.Errorf("目标规则库缺少维度：%s", strings.Join(missing, ","))
+	}
+	return
+}
+
+// IdMap 源规则id -> 目标规则id，不重新分配时冲突即报错；目标库中他人预留的id视为已占用
+func (This RuleCopy) IdMap(rules, dstRules []public.RuleInfo, reserved []int) (idMap map[int]int, err error) {
+	used := map[int]bool{}
+	maxId := 0
+	for _, id := range reserved {
+		used[id] = true
+	}
+	for _, rule := range dstRules {
+		used[rule.RuleId] = true
+		if rule.RuleId > maxId {
+			maxId = rule.RuleId
+		}
+	}
+	idMap = map[int]int{}
+	conflicts := []int{}
+	for _, rule := range rules {
+		if _, ok := idMap[rule.RuleId]; ok {
+			continue
+		}
+		if !used[rule.RuleId] {
+			idMap[rule.RuleId] = rule.RuleId
+			used[rule.RuleId] = true
+			continue
+		}
+		if !This.Reassign {
+			conflicts = append(conflicts, rule.RuleId)
+			continue
+		}
+		for used[maxId+1] || utils.IsContainsInt(This.RuleIds, maxId+1) {
+			maxId++
+		}
+		maxId++
+		idMap[rule.RuleId] = maxId
+		used[maxId] = true
+	}
+	if len(conflicts) != 0 {
+		return idMap, fmt.Errorf("目标规则库中已存在规则id：%v", conflicts)
+	}
+	return
+}
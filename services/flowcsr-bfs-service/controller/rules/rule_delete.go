@@ -5,19 +5,24 @@ import (
 	"bigrule/common/logger"
 	"bigrule/pkg/utils"
 	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
 	"bigrule/services/flowcsr-bfs-service/model/public"
 	"bigrule/services/flowcsr-bfs-service/model/response"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"sort"
 	"strings"
 )
 
 type RuleDelete struct {
 	RepoId   int   `json:"repo_id"   binding:"required"`
-	RuleIds  []int `json:"rule_ids"  binding:"required"`
+	RuleIds  []int `json:"rule_ids"  binding:"required,min=1"`
 	TagOp    int   `json:"tag_op"    binding:"required"`
+	DryRun   bool  `json:"dry_run"` // 仅返回删除预览
+	Partial  bool  `json:"partial"` // 每条规则单独提交，返回每条规则的结果
 	lineNums []int
 }
 
@@ -37,72 +42,213 @@ func (This RuleDelete) DoHandle(c *gin.Context) *ico.Result {
 		return ico.Err(2007, "权限不足")
 	}
 	// 1.规则查询
-	ruleDataList, code, err := This.GetRuleData(token)
+	ruleDataList, code, err := public.GetRules(token, This.RepoId, This.RuleIds)
 	if err != nil {
 		return ico.Err(code, err.Error())
 	}
-	message := fmt.Sprint("规则删除： ")
 	// 2.查询标签
 	deleteTagRes := []DeleteTag{}
-	if This.TagOp == 2 {
+	if This.TagOp == 2 && (!This.Partial || This.DryRun) {
 		// 2.1 多条重复id判断
 		if len(ruleDataList.List) != len(This.RuleIds) {
-			public.Cancel(token)
 			return ico.Err(2301, "有多条重复id规则")
 		}
 		// 2.2 获取标签
-		deleteTagRes, code, err = This.GetTag(token, ruleDataList)
+		deleteTagRes, code, err = This.GetTag(token, ruleDataList, nil)
 		if err != nil {
-			public.Cancel(token)
 			return ico.Err(code, err.Error())
 		}
 	}
+	if This.DryRun {
+		return ico.Succ(This.Plan(ruleDataList, deleteTagRes))
+	}
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	audit.AddRefs(c, audit.KindRule, This.RuleIds...)
+	for _, deleteTag := range deleteTagRes {
+		audit.AddRefs(c, audit.KindTag, deleteTag.TagvalIds...)
+	}
+	if This.Partial {
+		res := This.HandlePartial(token)
+		message := res.ChangeSet.Summary()
+		logger.Info(message)
+		audit.SetMessage(c, message)
+		return ico.Succ(res)
+	}
 	// 3.删除规则
-	messageRule := " 删除规则：["
+	j := journal.Begin(token, scheduledOperation(c, "规则删除"))
+	j.Snapshot(This.RepoId, ruleDataList.List...)
 	for _, ruleData := range ruleDataList.List {
+		step := j.Plan("删除规则",
+			journal.Call{Path: "/v1/rule-repo/rule/delete", Params: map[string]interface{}{"repo_id": This.RepoId, "rule_id": ruleData.RuleId, "line_num": ruleData.LineNum}},
+			journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": This.RepoId, "messages": []public.RuleMessage{ruleData.Message()}}},
+		)
 		if code, err := This.DeleteRule(token, ruleData.RuleId, ruleData.LineNum); err != nil {
-			public.Cancel(token)
+			j.Abort()
 			return ico.Err(code, err.Error())
 		}
-		messageRule += fmt.Sprint(ruleData.RuleId)
+		j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: ruleData.RuleId, LineNum: ruleData.LineNum})
+		j.Done(step)
 	}
-	message += messageRule + "]"
 	// 4.删除标签
 	if len(deleteTagRes) != 0 {
-		messageTag := " 删除标签：["
 		for _, deleteTag := range deleteTagRes {
 			logger.Info(deleteTag)
+			step := j.Plan("删除标签",
+				journal.Call{Path: "/v1/tag/delete", Params: deleteTag},
+				journal.Call{Path: "/v1/tag/add", Params: map[string]interface{}{"tagval_tbl_id": deleteTag.TagValTblId, "data": deleteTag.Tags}},
+			)
 			if code, err := This.DeleteTag(token, deleteTag); err != nil {
-				public.Cancel(token)
+				j.Abort()
 				return ico.Err(code, err.Error())
 			}
-			messageTag += fmt.Sprint(deleteTag.TagvalIds)
+			for i, tagId := range deleteTag.TagvalIds {
+				j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeTag, RepoId: deleteTag.TagValTblId, Id: tagId, LineNum: deleteTag.LineNums[i]})
+			}
+			j.Done(step)
 		}
-		message += messageTag + "]"
 	}
+	message := j.ChangeSet.Summary()
 	logger.Info(message)
-	public.Commit(token, message)
-	return ico.Succ("删除成功")
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(response.WriteRes{Message: "删除成功", ChangeSet: *j.ChangeSet})
+}
+
+// HandlePartial 部分成功模式，每条规则及其标签单独提交；前面的删除会改变行号，执行前重新查询
+func (This RuleDelete) HandlePartial(token string) *response.PartialRes {
+	res := response.NewPartialRes("规则删除")
+	done := []int{}
+	deleted := map[int][]int{}
+	for _, ruleId := range This.RuleIds {
+		if utils.IsContainsInt(done, ruleId) {
+			continue
+		}
+		done = append(done, ruleId)
+		changeSet, code, err := This.deleteOne(token, ruleId, deleted)
+		if err != nil {
+			res.Fail(response.ChangeRule, ruleId, code, err.Error())
+			continue
+		}
+		res.Succeed(response.ChangeRule, ruleId, changeSet)
+	}
+	return res.Finish()
+}
+
+// deleteOne 删除单条规则的全部行，tag_op为2时一并删除其标签；deleted为前面的规则已删除的标签，删除成功后追加
+func (This RuleDelete) deleteOne(token string, ruleId int, deleted map[int][]int) (changeSet *response.ChangeSet, code int, err error) {
+	// 1.规则查询，行号倒序删除
+	ruleDataList, code, err := public.GetRules(token, This.RepoId, []int{ruleId})
+	if err != nil {
+		return
+	}
+	if len(ruleDataList.List) == 0 {
+		return nil, 2301, fmt.Errorf("规则[%d]不存在", ruleId)
+	}
+	sort.Slice(ruleDataList.List, func(i, k int) bool {
+		return ruleDataList.List[i].LineNum > ruleDataList.List[k].LineNum
+	})
+	// 2.查询标签，已被前面的规则删除的标签跳过
+	deleteTagRes := []DeleteTag{}
+	if This.TagOp == 2 {
+		if len(ruleDataList.List) != 1 {
+			return nil, 2301, errors.New("有多条重复id规则")
+		}
+		if deleteTagRes, code, err = This.GetTag(token, ruleDataList, deleted); err != nil {
+			return
+		}
+		for i := range deleteTagRes {
+			deleteTagRes[i].TagvalIds = []int{}
+			for _, tag := range deleteTagRes[i].Tags {
+				deleteTagRes[i].TagvalIds = append(deleteTagRes[i].TagvalIds, tag.Id)
+			}
+		}
+	}
+	// 3.删除规则及标签
+	j := journal.Begin(token, "规则删除")
+	j.Snapshot(This.RepoId, ruleDataList.List...)
+	for _, ruleData := range ruleDataList.List {
+		step := j.Plan("删除规则",
+			journal.Call{Path: "/v1/rule-repo/rule/delete", Params: map[string]interface{}{"repo_id": This.RepoId, "rule_id": ruleData.RuleId, "line_num": ruleData.LineNum}},
+			journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": This.RepoId, "messages": []public.RuleMessage{ruleData.Message()}}},
+		)
+		if code, err = This.DeleteRule(token, ruleData.RuleId, ruleData.LineNum); err != nil {
+			j.Abort()
+			return
+		}
+		j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: ruleData.RuleId, LineNum: ruleData.LineNum})
+		j.Done(step)
+	}
+	for _, deleteTag := range deleteTagRes {
+		if len(deleteTag.TagvalIds) == 0 {
+			continue
+		}
+		step := j.Plan("删除标签",
+			journal.Call{Path: "/v1/tag/delete", Params: deleteTag},
+			journal.Call{Path: "/v1/tag/add", Params: map[string]interface{}{"tagval_tbl_id": deleteTag.TagValTblId, "data": deleteTag.Tags}},
+		)
+		if code, err = This.DeleteTag(token, deleteTag); err != nil {
+			j.Abort()
+			return
+		}
+		for i, tagId := range deleteTag.TagvalIds {
+			j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeTag, RepoId: deleteTag.TagValTblId, Id: tagId, LineNum: deleteTag.LineNums[i]})
+		}
+		j.Done(step)
+	}
+	if code, err = j.Commit(); err != nil {
+		j.Abort()
+		return
+	}
+	for _, deleteTag := range deleteTagRes {
+		deleted[deleteTag.TagValTblId] = append(deleted[deleteTag.TagValTblId], deleteTag.TagvalIds...)
+	}
+	return j.ChangeSet, 0, nil
+}
+
+// Plan 删除预览
+func (This RuleDelete) Plan(ruleDataList public.RuleListRes, deleteTagRes []DeleteTag) response.Plan {
+	plan := response.NewPlan()
+	for _, ruleData := range ruleDataList.List {
+		plan.DeleteRules = append(plan.DeleteRules, response.PlanRule{RepoId: This.RepoId, RuleId: ruleData.RuleId, LineNum: ruleData.LineNum})
+	}
+	for _, deleteTag := range deleteTagRes {
+		for i, tag := range deleteTag.Tags {
+			plan.DeleteTags = append(plan.DeleteTags, response.PlanTag{
+				TagValTblId: deleteTag.TagValTblId, TagId: tag.Id, TagName: tag.Value, LineNum: deleteTag.LineNums[i],
+			})
+		}
+	}
+	return plan
 }
 
 type DeleteTag struct {
 	TagValTblId int   `json:"tagval_tbl_id"`
 	TagvalIds   []int `json:"tagval_ids"`
 	LineNums    []int `json:"line_nums"`
+	Tags        []Tag `json:"-"`
 }
 
-func (This RuleDelete) GetTag(token string, ruleDataList RuleListRes) (deleteTagRes []DeleteTag, code int, err error) {
+// GetTag 规则关联的标签，按标签表分组去重，deleted中已删除的标签跳过
+func (This RuleDelete) GetTag(token string, ruleDataList public.RuleListRes, deleted map[int][]int) (deleteTagRes []DeleteTag, code int, err error) {
 	// 1.获取标签表，id
 	// 多条规则
 	for _, ruleData := range ruleDataList.List {
 		// 单条规则的多个维度
 		for _, dim := range ruleData.Dimensions {
+			if utils.IsContainsInt(deleted[dim.TagValTblId], dim.TagId) {
+				continue
+			}
 			// 增加标签
 			temp := true
 			for i, deleteTag := range deleteTagRes {
 				if dim.TagValTblId == deleteTag.TagValTblId {
 					temp = false
-					deleteTagRes[i].TagvalIds = append(deleteTagRes[i].TagvalIds, dim.TagId)
+					if !utils.IsContainsInt(deleteTag.TagvalIds, dim.TagId) {
+						deleteTagRes[i].TagvalIds = append(deleteTagRes[i].TagvalIds, dim.TagId)
+					}
 				}
 			}
 			// 增加表
@@ -111,43 +257,26 @@ func (This RuleDelete) GetTag(token string, ruleDataList RuleListRes) (deleteTag
 			}
 		}
 	}
-	// 2.获取标签行号
-	for i, deleteTag := range deleteTagRes {
+	// 2.获取标签行号，上游已不存在的标签跳过
+	found := []DeleteTag{}
+	for _, deleteTag := range deleteTagRes {
 		tagDataList, code, err := This.GetTagData(token, deleteTag.TagValTblId, deleteTag.TagvalIds)
 		if err != nil {
 			logger.Info("数据查询失败")
 			return deleteTagRes, code, errors.New("数据查询失败")
 		}
+		if len(tagDataList.List) == 0 {
+			continue
+		}
+		deleteTag.TagvalIds = []int{}
 		for _, tagData := range tagDataList.List {
-			deleteTagRes[i].LineNums = append(deleteTagRes[i].LineNums, tagData.LineNum)
+			deleteTag.TagvalIds = append(deleteTag.TagvalIds, tagData.Id)
+			deleteTag.LineNums = append(deleteTag.LineNums, tagData.LineNum)
+			deleteTag.Tags = append(deleteTag.Tags, Tag{Id: tagData.Id, Value: tagData.Name})
 		}
+		found = append(found, deleteTag)
 	}
-	return
-}
-
-func (This RuleDelete) GetRuleData(token string) (resData RuleListRes, code int, err error) {
-	// 1.整理入参
-	pars := map[string]interface{}{"data_type": 2, "repo_id": This.RepoId, "type": 1, "rule_ids": This.RuleIds}
-	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/rule/query", middleware.GetAddr("repo-service"))
-	headers := map[string]string{"X-Access-Token": token}
-	// 2.获取数据
-	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
-	if err != nil {
-		logger.Info("数据查询失败")
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	res := RuleRes{}
-	err = json.Unmarshal(respRepo, &res)
-	if err != nil {
-		logger.Info("数据查询失败", string(respRepo), pars)
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	if res.Code != 200 {
-		logger.Info("数据查询失败")
-		return resData, res.Code, errors.New(res.Message)
-	}
-	resData = res.Data
-	return
+	return found, 0, nil
 }
 
 // 标签
@@ -167,6 +296,7 @@ type TagInfo struct {
 	Name    string `json:"name"`
 }
 
+// GetTagData 按id查询标签，不存在的标签不返回
 func (This *RuleDelete) GetTagData(token string, tagValTblId int, tagIds []int) (resData TagData, code int, err error) {
 	// 1.整理入参
 	pars := map[string]interface{}{"tagval_tbl_id": tagValTblId, "type": 1, "tagval_ids": tagIds}
@@ -184,11 +314,14 @@ func (This *RuleDelete) GetTagData(token string, tagValTblId int, tagIds []int)
 		logger.Info("数据查询失败", string(respRepo), pars)
 		return resData, 2301, errors.New("数据查询失败")
 	}
-	if res.Code != 200 || len(res.Data.List) == 0 {
+	if res.Code != 200 {
 		logger.Info("数据查询失败")
 		return resData, res.Code, errors.New(res.Message)
 	}
 	resData = res.Data
+	if resData.List == nil {
+		resData.List = []TagInfo{}
+	}
 	return
 }
 
@@ -0,0 +1,265 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 对比方式
+const (
+	diffById      = "id"
+	diffByPattern = "pattern"
+)
+
+// RuleDiff 规则对比，以RepoId为基准，与TargetRepoId或File对比
+type RuleDiff struct {
+	RepoId       int       `json:"repo_id"         binding:"required"`
+	TargetRepoId int       `json:"target_repo_id"`
+	File         *DiffFile `json:"file"`
+	Key          string    `json:"key"` // id（默认）、pattern
+}
+
+// DiffFile 与 /tags/export 导出格式一致，pattern为可选扩展
+type DiffFile struct {
+	RepoId   int          `json:"repo_id"`
+	RepoName string       `json:"repo_name"`
+	Rules    []DiffRule   `json:"rules"`
+	RepoAttr []DiffDimKey `json:"repo_attr"`
+}
+
+type DiffRule struct {
+	RuleId  int                `json:"rule_id"`
+	Tags    []DiffTag          `json:"tags"`
+	Attr    response.Attribute `json:"attr"`
+	Pattern map[string]string  `json:"pattern"`
+}
+
+type DiffTag struct {
+	TagKeyId int    `json:"tagkey_id"`
+	TagId    int    `json:"tag_id"`
+	TagName  string `json:"tag_name"`
+}
+
+type DiffDimKey struct {
+	TagKeyId   int    `json:"tagkey_id"`
+	TagKeyName string `json:"tagkey_name"`
+}
+
+type RuleDiffRes struct {
+	Added      []int         `json:"added"`   // 仅目标中存在的规则id
+	Removed    []int         `json:"removed"` // 仅基准中存在的规则id
+	Modified   []RuleChanged `json:"modified"`
+	Unchanged  int           `json:"unchanged"`
+	Duplicated []int         `json:"duplicated"` // 基准或目标中有多行的规则id，按行的先后依次对应
+}
+
+type RuleChanged struct {
+	RuleId        int         `json:"rule_id"`
+	LineNum       int         `json:"line_num"`
+	TargetRuleId  int         `json:"target_rule_id"`
+	TargetLineNum int         `json:"target_line_num"`
+	Fields        []FieldDiff `json:"fields"`
+}
+
+type FieldDiff struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+func (This RuleDiff) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则对比")
+	if This.Key == "" {
+		This.Key = diffById
+	}
+	if This.Key != diffById && This.Key != diffByPattern {
+		return ico.Err(2099, "对比方式异常")
+	}
+	if (This.TargetRepoId == 0) == (This.File == nil) {
+		return ico.Err(2099, "target_repo_id与file需且仅需指定一个")
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 1.基准规则
+	ruleDataList, code, err := public.GetRepoRules(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	// 2.对比目标
+	target := []public.RuleInfo{}
+	comparePattern := true
+	if This.File != nil {
+		target, comparePattern = This.File.Infos()
+	} else {
+		if !utils.IsContainsInt(permissionToken.RepoIds, This.TargetRepoId) {
+			return ico.Err(2007, "权限不足")
+		}
+		targetDataList, code, err := public.GetRepoRules(token, This.TargetRepoId)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		target = targetDataList.List
+	}
+	if This.Key == diffByPattern && !comparePattern {
+		return ico.Err(2099, "file中规则缺少pattern，无法按规则体对比")
+	}
+	return ico.Succ(Diff(ruleDataList.List, target, This.Key, comparePattern))
+}
+
+// Infos 转换为规则信息，所有规则均带pattern时才对比规则体
+func (f DiffFile) Infos() (rules []public.RuleInfo, hasPattern bool) {
+	dimNames := map[int]string{}
+	for _, dim := range f.RepoAttr {
+		dimNames[dim.TagKeyId] = dim.TagKeyName
+	}
+	hasPattern = len(f.Rules) != 0
+	for _, rule := range f.Rules {
+		if len(rule.Pattern) == 0 {
+			hasPattern = false
+		}
+		info := public.RuleMessage{Id: rule.RuleId, Attr: rule.Attr, Pattern: rule.Pattern}.Info()
+		for _, tag := range rule.Tags {
+			info.Dimensions = append(info.Dimensions, public.RuleDimension{
+				DimensionId: tag.TagKeyId, DimensionName: dimNames[tag.TagKeyId], TagId: tag.TagId, TagName: tag.TagName,
+			})
+		}
+		rules = append(rules, info)
+	}
+	return
+}
+
+// Diff 按规则id或规则体对应两组规则，comparePattern为false时不对比规则体
+// 同一个键有多行时按行的先后依次对应，不会互相覆盖
+func Diff(base, target []public.RuleInfo, key string, comparePattern bool) RuleDiffRes {
+	res := RuleDiffRes{Added: []int{}, Removed: []int{}, Modified: []RuleChanged{}, Duplicated: []int{}}
+	keyOf := func(rule public.RuleInfo) string {
+		if key == diffByPattern {
+			return patternKey(rule.Pattern)
+		}
+		return strconv.Itoa(rule.RuleId)
+	}
+	baseKeys, targetKeys := lineKeys(base, keyOf), lineKeys(target, keyOf)
+	for _, rules := range [][]public.RuleInfo{base, target} {
+		count := map[int]int{}
+		for _, rule := range rules {
+			count[rule.RuleId]++
+			if count[rule.RuleId] == 2 && !utils.IsContainsInt(res.Duplicated, rule.RuleId) {
+				res.Duplicated = append(res.Duplicated, rule.RuleId)
+			}
+		}
+	}
+	targetMap := map[string]public.RuleInfo{}
+	for i, rule := range target {
+		targetMap[targetKeys[i]] = rule
+	}
+	seen := map[string]bool{}
+	for i, rule := range base {
+		k := baseKeys[i]
+		seen[k] = true
+		other, ok := targetMap[k]
+		if !ok {
+			res.Removed = append(res.Removed, rule.RuleId)
+			continue
+		}
+		fields := diffFields(rule, other, comparePattern)
+		if len(fields) == 0 {
+			res.Unchanged++
+			continue
+		}
+		res.Modified = append(res.Modified, RuleChanged{RuleId: rule.RuleId, LineNum: rule.LineNum, TargetRuleId: other.RuleId, TargetLineNum: other.LineNum, Fields: fields})
+	}
+	for i, rule := range target {
+		if !seen[targetKeys[i]] {
+			res.Added = append(res.Added, rule.RuleId)
+		}
+	}
+	return res
+}
+
+// lineKeys 每行规则的对比键，同一个键第n次出现时追加序号
+func lineKeys(rules []public.RuleInfo, keyOf func(public.RuleInfo) string) []string {
+	keys := []string{}
+	count := map[string]int{}
+	for _, rule := range rules {
+		k := keyOf(rule)
+		keys = append(keys, k+"#"+strconv.Itoa(count[k]))
+		count[k]++
+	}
+	return keys
+}
+
+func diffFields(before, after public.RuleInfo, comparePattern bool) (fields []FieldDiff) {
+	add := func(field, b, a string) {
+		if b != a {
+			fields = append(fields, FieldDiff{Field: field, Before: b, After: a})
+		}
+	}
+	add("rule_id", strconv.Itoa(before.RuleId), strconv.Itoa(after.RuleId))
+	if comparePattern {
+		for _, k := range unionKeys(before.Pattern, after.Pattern) {
+			add("pattern."+k, before.Pattern[k], after.Pattern[k])
+		}
+	}
+	b, a := before.Attribute(), after.Attribute()
+	add("attr.priority", strconv.Itoa(b.Priority), strconv.Itoa(a.Priority))
+	add("attr.status", strconv.Itoa(b.Status), strconv.Itoa(a.Status))
+	add("attr.sample", b.Sample, a.Sample)
+	add("attr.desc", b.Desc, a.Desc)
+	beforeTags, afterTags := tagsOf(before), tagsOf(after)
+	for _, k := range unionKeys(beforeTags, afterTags) {
+		add("tags."+k, beforeTags[k], afterTags[k])
+	}
+	return
+}
+
+// tagsOf 维度 -> 标签，跨库时维度id不同，优先按维度名称对应
+func tagsOf(rule public.RuleInfo) map[string]string {
+	tags := map[string]string{}
+	for _, dim := range rule.Dimensions {
+		dimKey := dim.DimensionName
+		if dimKey == "" {
+			dimKey = strconv.Itoa(dim.DimensionId)
+		}
+		tags[dimKey] = strconv.Itoa(dim.TagId)
+	}
+	return tags
+}
+
+func patternKey(pattern map[string]string) string {
+	b, _ := json.Marshal(pattern)
+	return string(b)
+}
+
+func unionKeys(a, b map[string]string) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	rule := func(id, line int, url string, priority string) public.RuleInfo {
+		return public.RuleInfo{RuleId: id, LineNum: line, Pattern: map[string]string{"url": url}, Attributes: response.AttributeRes{Priority: priority, Status: "1"}}
+	}
+	cases := []struct {
+		name       string
+		base       []public.RuleInfo
+		target     []public.RuleInfo
+		key        string
+		added      []int
+		removed    []int
+		modified   []int // 基准行号
+		unchanged  int
+		duplicated []int
+	}{
+		{"相同", []public.RuleInfo{rule(1, 1, "a", "1")}, []public.RuleInfo{rule(1, 1, "a", "1")}, diffById,
+			[]int{}, []int{}, []int{}, 1, []int{}},
+		{"新增删除修改", []public.RuleInfo{rule(1, 1, "a", "1"), rule(2, 2, "b", "1")}, []public.RuleInfo{rule(1, 1, "a", "2"), rule(3, 2, "c", "1")}, diffById,
+			[]int{3}, []int{2}, []int{1}, 0, []int{}},
+		{"基准重复id逐行对应", []public.RuleInfo{rule(1, 1, "a", "1"), rule(1, 2, "b", "1")}, []public.RuleInfo{rule(1, 1, "a", "1")}, diffById,
+			[]int{}, []int{1}, []int{}, 1, []int{1}},
+		{"两边重复id逐行对比", []public.RuleInfo{rule(1, 1, "a", "1"), rule(1, 2, "b", "1")}, []public.RuleInfo{rule(1, 1, "a", "1"), rule(1, 2, "c", "1")}, diffById,
+			[]int{}, []int{}, []int{2}, 1, []int{1}},
+		{"按规则体对应", []public.RuleInfo{rule(1, 1, "a", "1")}, []public.RuleInfo{rule(5, 1, "a", "1")}, diffByPattern,
+			[]int{}, []int{}, []int{1}, 0, []int{}},
+		{"规则体重复", []public.RuleInfo{rule(1, 1, "a", "1"), rule(2, 2, "a", "1")}, []public.RuleInfo{rule(1, 1, "a", "1")}, diffByPattern,
+			[]int{}, []int{2}, []int{}, 1, []int{}},
+	}
+	for _, c := range cases {
+		res := Diff(c.base, c.target, c.key, true)
+		modified := []int{}
+		for _, changed := range res.Modified {
+			modified = append(modified, changed.LineNum)
+		}
+		if !reflect.DeepEqual(res.Added, c.added) || !reflect.DeepEqual(res.Removed, c.removed) || !reflect.DeepEqual(modified, c.modified) ||
+			res.Unchanged != c.unchanged || !reflect.DeepEqual(res.Duplicated, c.duplicated) {
+			t.Errorf("%s: got %+v", c.name, res)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+)
+
+// DuplicateQuery 重复id扫描，RepoIds为空时扫描有权限的全部规则库
+type DuplicateQuery struct {
+	RepoIds []int `json:"repo_ids"`
+}
+
+type DuplicateRepo struct {
+	RepoId       int               `json:"repo_id"`
+	RepoName     string            `json:"repo_name"`
+	ParserRepoId int               `json:"parser_repo_id"`
+	Rules        []DuplicateRule   `json:"rules"`
+	Parsers      []DuplicateParser `json:"parsers"`
+}
+
+type DuplicateRule struct {
+	RuleId int               `json:"rule_id"`
+	Lines  []public.RuleInfo `json:"lines"`
+}
+
+type DuplicateParser struct {
+	ParserId int                   `json:"parser_id"`
+	Lines    []DuplicateParserLine `json:"lines"`
+}
+
+type DuplicateParserLine struct {
+	LineNum int                    `json:"line_num"`
+	Raw     map[string]interface{} `json:"raw"`
+}
+
+func (This DuplicateQuery) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("重复id扫描")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	for _, repoId := range This.RepoIds {
+		if !utils.IsContainsInt(permissionToken.RepoIds, repoId) {
+			return ico.Err(2007, "权限不足")
+		}
+	}
+	// 1.规则库
+	repoDataList, code, err := public.GetRepos(token, This.RepoIds)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	// 2.逐库扫描，无解析规则库权限时不扫描解析规则
+	res := []DuplicateRepo{}
+	for _, repo := range repoDataList.List {
+		if !utils.IsContainsInt(permissionToken.RepoIds, repo.RepoId) {
+			continue
+		}
+		withParser := utils.IsContainsInt(permissionToken.ParserIds, repo.ParserMsg.Id)
+		dup, code, err := FindDuplicates(token, permissionToken.Token, repo, withParser)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		if len(dup.Rules) != 0 || len(dup.Parsers) != 0 {
+			res = append(res, dup)
+		}
+	}
+	return ico.Succ(res)
+}
+
+// FindDuplicates 查询规则库及其解析规则库中的重复id，按id排序
+func FindDuplicates(token, parserToken string, repo public.RepoInfo, withParser bool) (dup DuplicateRepo, code int, err error) {
+	dup = DuplicateRepo{RepoId: repo.RepoId, RepoName: repo.Name, ParserRepoId: repo.ParserMsg.Id, Rules: []DuplicateRule{}, Parsers: []DuplicateParser{}}
+	// 1.识别规则
+	ruleDataList, code, err := public.GetRepoRules(token, repo.RepoId)
+	if err != nil {
+		return
+	}
+	rules := map[int][]public.RuleInfo{}
+	for _, ruleData := range ruleDataList.List {
+		rules[ruleData.RuleId] = append(rules[ruleData.RuleId], ruleData)
+	}
+	for ruleId, lines := range rules {
+		if len(lines) > 1 {
+			dup.Rules = append(dup.Rules, DuplicateRule{RuleId: ruleId, Lines: lines})
+		}
+	}
+	sort.Slice(dup.Rules, func(i, j int) bool { return dup.Rules[i].RuleId < dup.Rules[j].RuleId })
+	// 2.解析规则
+	if !withParser || repo.ParserMsg.Id == 0 {
+		return
+	}
+	parserDataList, code, err := public.GetParsers(parserToken, repo.ParserMsg.Id, 0)
+	if err != nil {
+		return
+	}
+	parsers := map[int][]DuplicateParserLine{}
+	for _, parserData := range parserDataList {
+		parsers[parserData.ParserId] = append(parsers[parserData.ParserId], DuplicateParserLine{LineNum: parserData.LineNum, Raw: parserData.Raw})
+	}
+	for parserId, lines := range parsers {
+		if len(lines) > 1 {
+			dup.Parsers = append(dup.Parsers, DuplicateParser{ParserId: parserId, Lines: lines})
+		}
+	}
+	sort.Slice(dup.Parsers, func(i, j int) bool { return dup.Parsers[i].ParserId < dup.Parsers[j].ParserId })
+	return
+}
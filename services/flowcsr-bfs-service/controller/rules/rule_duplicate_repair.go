@@ -0,0 +1,280 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+)
+
+// 修复方式
+const (
+	repairRenumber = "renumber"
+	repairRemove   = "remove"
+)
+
+// DuplicateRepair 按行号修复重复id，规则重新编号或删除，解析规则只能删除；
+// 规则使用用户token，解析规则使用权限服务返回的token，两者分别暂存、提交
+type DuplicateRepair struct {
+	RepoId  int          `json:"repo_id"    binding:"required"`
+	Rules   []RepairItem `json:"rules"`
+	Parsers []RepairItem `json:"parsers"`
+}
+
+// RepairItem 规则重新编号时NewId为0则自动分配
+type RepairItem struct {
+	Id      int    `json:"id"         binding:"required"`
+	LineNum int    `json:"line_num"   binding:"required"`
+	Action  string `json:"action"     binding:"required"`
+	NewId   int    `json:"new_id"`
+}
+
+type DuplicateRepairRes struct {
+	response.WriteRes
+	Renumbered []Renumbered `json:"renumbered"`
+}
+
+// Renumbered 重新编号的规则移到规则库末尾，NewLineNum为新行号
+type Renumbered struct {
+	Type       string `json:"type"`
+	Id         int    `json:"id"`
+	LineNum    int    `json:"line_num"`
+	NewId      int    `json:"new_id"`
+	NewLineNum int    `json:"new_line_num"`
+}
+
+func (This DuplicateRepair) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("重复id修复")
+	if len(This.Rules) == 0 && len(This.Parsers) == 0 {
+		return ico.Err(2099, "rules与parsers不能同时为空")
+	}
+	for _, item := range This.Parsers {
+		if item.Action == repairRenumber {
+			return ico.Err(2099, fmt.Sprintf("解析规则[%d]不支持重新编号，上游没有解析规则新增接口，只能删除", item.Id))
+		}
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	repo, code, err := public.GetRepo(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	parserRepoId := repo.ParserMsg.Id
+	if len(This.Parsers) != 0 && !utils.IsContainsInt(permissionToken.ParserIds, parserRepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 解析规则与解析规则删除一致，使用权限服务返回的token；规则仍使用用户token
+	parserToken := permissionToken.Token
+	// 1.扫描重复id，修复项需属于重复id
+	dup, code, err := FindDuplicates(token, parserToken, repo, len(This.Parsers) != 0)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	ruleLines := map[int]map[int]public.RuleInfo{}
+	ruleSets := map[int]map[int]bool{}
+	for _, rule := range dup.Rules {
+		ruleLines[rule.RuleId] = map[int]public.RuleInfo{}
+		ruleSets[rule.RuleId] = map[int]bool{}
+		for _, line := range rule.Lines {
+			ruleLines[rule.RuleId][line.LineNum] = line
+			ruleSets[rule.RuleId][line.LineNum] = true
+		}
+	}
+	parserLines := map[int]map[int]map[string]interface{}{}
+	parserSets := map[int]map[int]bool{}
+	for _, parser := range dup.Parsers {
+		parserLines[parser.ParserId] = map[int]map[string]interface{}{}
+		parserSets[parser.ParserId] = map[int]bool{}
+		for _, line := range parser.Lines {
+			parserLines[parser.ParserId][line.LineNum] = line.Raw
+			parserSets[parser.ParserId][line.LineNum] = true
+		}
+	}
+	if err := checkRepair(This.Rules, ruleSets); err != nil {
+		return ico.Err(2098, "规则"+err.Error())
+	}
+	if err := checkRepair(This.Parsers, parserSets); err != nil {
+		return ico.Err(2098, "解析规则"+err.Error())
+	}
+	// 2.新编号
+	ruleIds, code, err := This.assignRuleIds(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	defer ruleid.Release(This.RepoId, ruleIds)
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	for _, item := range This.Rules {
+		audit.AddRefs(c, audit.KindRule, item.Id)
+	}
+	for _, item := range This.Parsers {
+		audit.AddRefs(c, audit.KindParser, item.Id)
+	}
+	// 3.修复，按行号倒序删除原行，避免行号变化
+	res := DuplicateRepairRes{Renumbered: []Renumbered{}}
+	j := journal.Begin(token, "重复id修复")
+	// 重新编号为删除原行后以新id新增
+	updates, removes := []RuleUpdateItem{}, []RuleUpdateItem{}
+	for _, item := range This.Rules {
+		before := ruleLines[item.Id][item.LineNum].Message()
+		if item.Action == repairRenumber {
+			after := before
+			after.Id = item.NewId
+			updates = append(updates, RuleUpdateItem{LineNum: item.LineNum, Before: before, After: after, Fields: []string{"id"}})
+		} else {
+			removes = append(removes, RuleUpdateItem{LineNum: item.LineNum, Before: before})
+		}
+	}
+	lineNums, code, err := ReplaceRules(token, j, This.RepoId, updates, removes...)
+	if err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	for _, item := range This.Rules {
+		// 重复id按行区分，重新编号的快照记在新id下
+		snapshot := ruleLines[item.Id][item.LineNum]
+		if item.Action == repairRenumber {
+			snapshot.RuleId = item.NewId
+		}
+		j.Snapshot(This.RepoId, snapshot)
+		if item.Action == repairRenumber {
+			j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: item.NewId, LineNum: lineNums[item.NewId], Fields: []string{"id"}})
+			res.Renumbered = append(res.Renumbered, Renumbered{Type: response.ChangeRule, Id: item.Id, LineNum: item.LineNum, NewId: item.NewId, NewLineNum: lineNums[item.NewId]})
+		} else {
+			j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: item.Id, LineNum: item.LineNum})
+		}
+	}
+	// 3.1 解析规则按行号倒序删除，暂存在权限服务token下，单独记录
+	changeSet := *j.ChangeSet
+	var pj *journal.Journal
+	if len(This.Parsers) != 0 {
+		pj = journal.Begin(parserToken, "重复id修复")
+		sort.SliceStable(This.Parsers, func(i, k int) bool { return This.Parsers[i].LineNum > This.Parsers[k].LineNum })
+		for _, item := range This.Parsers {
+			raw := parserLines[item.Id][item.LineNum]
+			forward := journal.Call{Path: "/v1/parser-repo/parser/delete", Params: map[string]interface{}{"parser_repo_id": parserRepoId, "parser_id": item.Id, "line_num": item.LineNum}}
+			step := pj.Plan("删除解析规则", forward)
+			// 上游没有解析规则新增接口，保留原始数据用于人工恢复
+			pj.Keep(step, raw)
+			if code, err := journal.Exec(parserToken, forward); err != nil {
+				pj.Abort()
+				j.Abort()
+				return ico.Err(code, err.Error())
+			}
+			pj.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeParser, RepoId: parserRepoId, Id: item.Id, LineNum: item.LineNum})
+			pj.Done(step)
+		}
+		// 返回及审计的变更包含规则和解析规则
+		changeSet.Deleted = append(append([]response.ChangeItem{}, j.ChangeSet.Deleted...), pj.ChangeSet.Deleted...)
+	}
+	message := changeSet.Summary()
+	logger.Info(message)
+	audit.SetMessage(c, message)
+	// 4.先提交规则，再提交解析规则
+	if code, err := j.Commit(); err != nil {
+		if pj != nil {
+			pj.Abort()
+		}
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	if pj != nil {
+		if code, err := pj.Commit(); err != nil {
+			pj.Abort()
+			return ico.Err(code, "规则已修复，解析规则删除失败："+err.Error())
+		}
+	}
+	res.WriteRes = response.WriteRes{Message: "修复成功", ChangeSet: changeSet}
+	return ico.Succ(res)
+}
+
+// assignRuleIds 校验指定的新规则id未被占用，未指定的预留新id，返回预留的id
+func (This *DuplicateRepair) assignRuleIds(token string) (reserved []int, code int, err error) {
+	count := 0
+	newIds := []int{}
+	for _, item := range This.Rules {
+		if item.Action != repairRenumber {
+			continue
+		}
+		if item.NewId == 0 {
+			count++
+			continue
+		}
+		if utils.IsContainsInt(newIds, item.NewId) {
+			return nil, 2098, fmt.Errorf("新规则id[%d]重复", item.NewId)
+		}
+		newIds = append(newIds, item.NewId)
+	}
+	if len(newIds) != 0 {
+		ruleDataList, code, err := public.GetRules(token, This.RepoId, newIds)
+		if err != nil {
+			return nil, code, err
+		}
+		if len(ruleDataList.List) != 0 {
+			return nil, 2098, fmt.Errorf("新规则id[%d]已存在", ruleDataList.List[0].RuleId)
+		}
+	}
+	if count == 0 {
+		return
+	}
+	reserved, _, code, err = ReserveRuleIds(token, This.RepoId, count, ruleid.DefaultTTL)
+	if err != nil {
+		return
+	}
+	next := 0
+	for i, item := range This.Rules {
+		if item.Action == repairRenumber && item.NewId == 0 {
+			This.Rules[i].NewId = reserved[next]
+			next++
+		}
+	}
+	return
+}
+
+// checkRepair 修复项需为重复id的某一行，每行只能修复一次，且不能删除某id的全部行
+func checkRepair(items []RepairItem, lineSets map[int]map[int]bool) error {
+	touched := map[int]map[int]bool{}
+	removed := map[int]int{}
+	for _, item := range items {
+		if item.Action != repairRenumber && item.Action != repairRemove {
+			return fmt.Errorf("[%d]修复方式异常", item.Id)
+		}
+		lines := lineSets[item.Id]
+		if !lines[item.LineNum] {
+			return fmt.Errorf("[%d]第%d行不是重复id", item.Id, item.LineNum)
+		}
+		if touched[item.Id] == nil {
+			touched[item.Id] = map[int]bool{}
+		}
+		if touched[item.Id][item.LineNum] {
+			return fmt.Errorf("[%d]第%d行重复修复", item.Id, item.LineNum)
+		}
+		touched[item.Id][item.LineNum] = true
+		if item.Action == repairRemove {
+			removed[item.Id]++
+			if removed[item.Id] == len(lines) {
+				return fmt.Errorf("[%d]不能删除全部行", item.Id)
+			}
+		}
+	}
+	return nil
+}
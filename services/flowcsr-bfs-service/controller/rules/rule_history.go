@@ -0,0 +1,56 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/history"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// RuleHistory 单条规则的变更时间线
+type RuleHistory struct {
+	RepoId    int `json:"repo_id"    binding:"required"`
+	RuleId    int `json:"rule_id"    binding:"required"`
+	PageSize  int `json:"page_size"`
+	PageIndex int `json:"page_index"`
+}
+
+type RuleHistoryRes struct {
+	Total int64                 `json:"total"`
+	List  []history.RuleHistory `json:"list"`
+}
+
+func (This RuleHistory) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则历史查询")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	if This.PageSize <= 0 {
+		This.PageSize = 20
+	}
+	if This.PageIndex <= 0 {
+		This.PageIndex = 1
+	}
+	list, total, err := history.Query(This.RepoId, This.RuleId, This.PageSize, This.PageIndex)
+	if err != nil {
+		logger.Error("规则历史查询失败 ", err.Error())
+		return ico.Err(2301, "数据查询失败")
+	}
+	if list == nil {
+		list = []history.RuleHistory{}
+	}
+	return ico.Succ(RuleHistoryRes{Total: total, List: list})
+}
@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/config"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"time"
+)
+
+// RuleIdReserve 预留未使用的规则id，写入后或过期时释放
+type RuleIdReserve struct {
+	RepoId int `json:"repo_id"  binding:"required"`
+	Count  int `json:"count"    binding:"required"`
+	Ttl    int `json:"ttl"` // 秒，默认30分钟，不能超过配置的上限
+}
+
+type RuleIdReserveRes struct {
+	RuleIds   []int     `json:"rule_ids"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (This RuleIdReserve) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则id预留")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	if This.Count <= 0 || This.Count > ruleid.MaxCount {
+		return ico.Err(2099, fmt.Sprintf("预留数量需在1-%d之间", ruleid.MaxCount))
+	}
+	if This.Ttl > config.RuleConfig.MaxReserve {
+		return ico.Err(2099, fmt.Sprintf("ttl不能大于%d秒", config.RuleConfig.MaxReserve))
+	}
+	ttl := ruleid.DefaultTTL
+	if This.Ttl > 0 {
+		ttl = time.Duration(This.Ttl) * time.Second
+	}
+	ruleIds, expiresAt, code, err := ReserveRuleIds(token, This.RepoId, This.Count, ttl)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(RuleIdReserveRes{RuleIds: ruleIds, ExpiresAt: expiresAt})
+}
+
+// ReserveRuleIds 按规则库现有规则id预留count个id
+func ReserveRuleIds(token string, repoId, count int, ttl time.Duration) (ruleIds []int, expiresAt time.Time, code int, err error) {
+	ruleDataList, code, err := public.GetRepoRules(token, repoId)
+	if err != nil {
+		return
+	}
+	existing := []int{}
+	for _, ruleData := range ruleDataList.List {
+		existing = append(existing, ruleData.RuleId)
+	}
+	ruleIds, expiresAt, err = ruleid.Reserve(repoId, existing, count, ReservationOwner(token), middleware.GetCaller(token).UserName, ttl)
+	if err != nil {
+		logger.Error("规则id预留失败 ", err.Error())
+		return ruleIds, expiresAt, 2301, err
+	}
+	return
+}
+
+// ReservationOwner 预留方，调用方身份可校验时为用户名，否则为token摘要，不保存token原文
+func ReservationOwner(token string) string {
+	if userName := middleware.GetCaller(token).UserName; userName != "" {
+		return "user:" + userName
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,193 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RuleImport 从csv、xlsx、yaml文件导入规则，multipart上传，文件字段为file
+type RuleImport struct {
+	RepoId      int    `form:"repo_id"         binding:"required"`
+	TagValTblId int    `form:"tagval_tbl_id"   binding:"required"`
+	Format      string `form:"format"`                             // 默认按文件扩展名
+	Mapping     string `form:"mapping"         binding:"required"` // ImportMapping的json
+	AutoId      bool   `form:"auto_id"`
+	Regression  bool   `form:"regression"`
+}
+
+// ImportMapping 字段与文件列名的对应关系
+type ImportMapping struct {
+	Id       string            `json:"id"`
+	Pattern  map[string]string `json:"pattern"` // 规则体key -> 列名
+	Priority string            `json:"priority"`
+	Status   string            `json:"status"`
+	Sample   string            `json:"sample"`
+	Desc     string            `json:"desc"`
+	Tags     []ImportTagColumn `json:"tags"`
+}
+
+// ImportTagColumn 维度对应的标签列，IdColumn为标签id列，NameColumn不为空时按id新增标签
+type ImportTagColumn struct {
+	DimensionId int    `json:"dimension_id"`
+	IdColumn    string `json:"id"`
+	NameColumn  string `json:"name"`
+}
+
+// RowError 文件中某一行的错误
+type RowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+func (This RuleImport) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBind(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	logger.Info("规则导入")
+	mapping := ImportMapping{}
+	if err := json.Unmarshal([]byte(This.Mapping), &mapping); err != nil {
+		return ico.Err(2099, "mapping格式错误", err.Error())
+	}
+	// 1.解析文件
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return ico.Err(2099, "缺少文件", err.Error())
+	}
+	if fileHeader.Size > MaxImportSize {
+		return ico.Err(2099, fmt.Sprintf("文件不能超过%dMB", MaxImportSize>>20))
+	}
+	format := strings.ToLower(This.Format)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), ".")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return ico.Err(2099, "文件读取失败", err.Error())
+	}
+	defer file.Close()
+	table, err := ReadImportFile(format, file)
+	if err != nil {
+		return ico.Err(2099, err.Error())
+	}
+	if missing := mapping.Missing(table, This.AutoId); len(missing) != 0 {
+		return ico.Err(2099, fmt.Sprintf("文件缺少列：%s", strings.Join(missing, ",")))
+	}
+	// 2.整理为规则新增入参
+	add, rowErrors := This.RuleAdd(table, mapping)
+	if len(rowErrors) != 0 {
+		res := ico.Err(2098, "规则校验失败")
+		res.Data = rowErrors
+		return res
+	}
+	// 3.与批量新增相同的流程，校验错误转换为行号
+	res := add.Handle(c)
+	if ruleErrors, ok := res.Data.([]RuleError); ok {
+		rowErrors = []RowError{}
+		for _, ruleError := range ruleErrors {
+			rowErrors = append(rowErrors, RowError{Line: table.Lines[ruleError.Index], Errors: ruleError.Errors})
+		}
+		res.Data = rowErrors
+	}
+	return res
+}
+
+// Missing 映射中指定但文件中不存在的列
+func (m ImportMapping) Missing(table ImportTable, autoId bool) (missing []string) {
+	columns := []string{m.Priority, m.Status, m.Sample, m.Desc}
+	if !autoId {
+		columns = append(columns, m.Id)
+	}
+	for _, column := range m.Pattern {
+		columns = append(columns, column)
+	}
+	for _, tag := range m.Tags {
+		columns = append(columns, tag.IdColumn, tag.NameColumn)
+	}
+	for _, column := range columns {
+		if column != "" && !table.HasColumn(column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(m.Pattern) == 0 {
+		missing = append(missing, "pattern")
+	}
+	return
+}
+
+// RuleAdd 按映射逐行整理规则及标签，返回每行的格式错误
+func (This RuleImport) RuleAdd(table ImportTable, mapping ImportMapping) (add RuleAdd, rowErrors []RowError) {
+	add = RuleAdd{RepoId: This.RepoId, TagVal: &TagVal{TagValTblId: This.TagValTblId, Tag: []Tag{}}, AutoId: This.AutoId, Regression: This.Regression}
+	tagNames := map[int]string{}
+	for i := range table.Rows {
+		errs := []string{}
+		intOf := func(column, name string) int {
+			if column == "" {
+				return 0
+			}
+			v, err := parseCellInt(table.Get(i, column))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s[%s]不是整数", name, table.Get(i, column)))
+			}
+			return v
+		}
+		rule := Rule{Pattern: map[string]string{}, Dimensions: []DimensionAddRule{}}
+		if !This.AutoId {
+			rule.Id = intOf(mapping.Id, "id")
+		}
+		for key, column := range mapping.Pattern {
+			if v := table.Get(i, column); v != "" {
+				rule.Pattern[key] = v
+			}
+		}
+		rule.Attr = response.Attribute{
+			Priority: intOf(mapping.Priority, "priority"), Status: intOf(mapping.Status, "status"),
+			Sample: table.Get(i, mapping.Sample), Desc: table.Get(i, mapping.Desc),
+		}
+		for _, tagColumn := range mapping.Tags {
+			if table.Get(i, tagColumn.IdColumn) == "" {
+				continue
+			}
+			tagId := intOf(tagColumn.IdColumn, "标签id")
+			rule.Dimensions = append(rule.Dimensions, DimensionAddRule{DimensionId: tagColumn.DimensionId, ValueId: tagId})
+			if tagColumn.NameColumn == "" {
+				continue
+			}
+			name := table.Get(i, tagColumn.NameColumn)
+			if old, ok := tagNames[tagId]; ok {
+				if old != name {
+					errs = append(errs, fmt.Sprintf("标签[%d]名称与前面的行不一致", tagId))
+				}
+				continue
+			}
+			tagNames[tagId] = name
+			add.TagVal.Tag = append(add.TagVal.Tag, Tag{Id: tagId, Value: name})
+		}
+		if len(errs) != 0 {
+			rowErrors = append(rowErrors, RowError{Line: table.Lines[i], Errors: errs})
+		}
+		add.RuleList = append(add.RuleList, rule)
+	}
+	return
+}
+
+// parseCellInt 表格中的数字可能为"1.0"
+func parseCellInt(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if i, err := strconv.Atoi(v); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f != float64(int(f)) {
+		return 0, fmt.Errorf("%s不是整数", v)
+	}
+	return int(f), nil
+}
@@ -0,0 +1,330 @@
+package rules
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 导入文件格式
+const (
+	formatCsv  = "csv"
+	formatXlsx = "xlsx"
+	formatYaml = "yaml"
+)
+
+// 导入文件的大小、列数上限，xlsx解压后的单个文件同样受大小限制
+const (
+	MaxImportSize    = 10 << 20
+	maxImportColumns = 1000
+)
+
+// ImportTable 导入文件解析结果，Rows中每行与Header按列对应，Lines为行在文件中的行号
+type ImportTable struct {
+	Header []string
+	Rows   [][]string
+	Lines  []int
+}
+
+// Get 按列名取值，列不存在时返回空
+func (t ImportTable) Get(row int, column string) string {
+	for i, name := range t.Header {
+		if name == column && i < len(t.Rows[row]) {
+			return strings.TrimSpace(t.Rows[row][i])
+		}
+	}
+	return ""
+}
+
+// HasColumn 文件中是否有该列
+func (t ImportTable) HasColumn(column string) bool {
+	for _, name := range t.Header {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadImportFile 按格式解析导入文件，csv、xlsx首行为列名，yaml为对象数组
+func ReadImportFile(format string, r io.Reader) (table ImportTable, err error) {
+	r = &limitReader{r: r, n: MaxImportSize}
+	switch format {
+	case formatCsv:
+		return readCsv(r)
+	case formatXlsx:
+		return readXlsx(r)
+	case formatYaml, "yml":
+		return readYaml(r)
+	}
+	return table, fmt.Errorf("不支持的文件格式：%s", format)
+}
+
+func readCsv(r io.Reader) (table ImportTable, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return
+	}
+	return newTable(records)
+}
+
+func newTable(records [][]string) (table ImportTable, err error) {
+	if len(records) == 0 {
+		return table, errors.New("文件为空")
+	}
+	for _, record := range records {
+		if len(record) > maxImportColumns {
+			return table, fmt.Errorf("列数不能超过%d", maxImportColumns)
+		}
+	}
+	for _, name := range records[0] {
+		table.Header = append(table.Header, strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	}
+	for i, record := range records[1:] {
+		if isBlank(record) {
+			continue
+		}
+		table.Rows = append(table.Rows, record)
+		table.Lines = append(table.Lines, i+2)
+	}
+	return
+}
+
+func isBlank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func readYaml(r io.Reader) (table ImportTable, err error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	items := []map[string]interface{}{}
+	if err = yaml.Unmarshal(b, &items); err != nil {
+		return
+	}
+	if len(items) == 0 {
+		return table, errors.New("文件为空")
+	}
+	columns := map[string]bool{}
+	for _, item := range items {
+		for k := range item {
+			columns[k] = true
+		}
+	}
+	if len(columns) > maxImportColumns {
+		return table, fmt.Errorf("列数不能超过%d", maxImportColumns)
+	}
+	for k := range columns {
+		table.Header = append(table.Header, k)
+	}
+	sort.Strings(table.Header)
+	for i, item := range items {
+		row := []string{}
+		for _, k := range table.Header {
+			if v, ok := item[k]; ok && v != nil {
+				row = append(row, fmt.Sprint(v))
+			} else {
+				row = append(row, "")
+			}
+		}
+		table.Rows = append(table.Rows, row)
+		table.Lines = append(table.Lines, i+1)
+	}
+	return
+}
+
+// xlsx 只读取第一个工作表的单元格文本
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		T    string `xml:"t"`
+		Runs []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string `xml:"r,attr"`
+			T  string `xml:"t,attr"`
+			V  string `xml:"v"`
+			Is struct {
+				T string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Id string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Items []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+func readXlsx(r io.Reader) (table ImportTable, err error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return table, errors.New("xlsx文件格式错误")
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	// 1.共享字符串
+	shared := []string{}
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		ss := xlsxSharedStrings{}
+		if err = readXml(f, &ss); err != nil {
+			return
+		}
+		for _, item := range ss.Items {
+			text := item.T
+			for _, run := range item.Runs {
+				text += run.T
+			}
+			shared = append(shared, text)
+		}
+	}
+	// 2.第一个工作表
+	sheetName := "xl/worksheets/sheet1.xml"
+	workbook, rels := xlsxWorkbook{}, xlsxRels{}
+	if readXml(files["xl/workbook.xml"], &workbook) == nil && readXml(files["xl/_rels/workbook.xml.rels"], &rels) == nil && len(workbook.Sheets) != 0 {
+		for _, rel := range rels.Items {
+			if rel.Id == workbook.Sheets[0].Id {
+				sheetName = path.Join("xl", strings.TrimPrefix(rel.Target, "/xl/"))
+			}
+		}
+	}
+	sheet := xlsxSheet{}
+	if err = readXml(files[sheetName], &sheet); err != nil {
+		return table, errors.New("xlsx文件格式错误")
+	}
+	// 3.按单元格坐标还原行列
+	records := [][]string{}
+	lines := []int{}
+	for _, row := range sheet.Rows {
+		record := []string{}
+		for i, cell := range row.Cells {
+			col := columnIndex(cell.R)
+			if col < 0 {
+				col = i
+			}
+			if col >= maxImportColumns {
+				return table, fmt.Errorf("单元格[%s]超出列数上限%d", cell.R, maxImportColumns)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			switch cell.T {
+			case "s":
+				idx, _ := strconv.Atoi(cell.V)
+				if idx >= 0 && idx < len(shared) {
+					record[col] = shared[idx]
+				}
+			case "inlineStr":
+				record[col] = cell.Is.T
+			default:
+				record[col] = cell.V
+			}
+		}
+		records = append(records, record)
+		lines = append(lines, row.R)
+	}
+	table, err = newTable(records)
+	if err != nil {
+		return
+	}
+	// 行号以表格中的为准
+	table.Lines = table.Lines[:0]
+	for i, record := range records[1:] {
+		if !isBlank(record) {
+			table.Lines = append(table.Lines, lines[i+1])
+		}
+	}
+	return
+}
+
+func readXml(f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("文件不存在")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(&limitReader{r: rc, n: MaxImportSize}).Decode(v)
+}
+
+// columnIndex 单元格坐标（如"AB12"）转为从0开始的列下标，超出列数上限时返回上限
+func columnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+		n++
+		if col > maxImportColumns {
+			return maxImportColumns
+		}
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+// limitReader 超过n字节时报错，避免截断后按不完整的文件解析
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		if _, err := l.r.Read(make([]byte, 1)); err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("文件不能超过%dMB", MaxImportSize>>20)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xlsxFile 按文件名 -> 内容生成xlsx
+func xlsxFile(t *testing.T, files map[string]string) string {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func sheetXml(rows string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+func TestReadImportFile(t *testing.T) {
+	sharedStrings := `<sst><si><t>id</t></si><si><t>url</t></si><si><r><t>a\.</t></r><r><t>com</t></r></si></sst>`
+	workbook := `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet r:id="rId2"/></sheets></workbook>`
+	rels := `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="worksheets/sheet2.xml"/></Relationships>`
+	cases := []struct {
+		name    string
+		format  string
+		content string
+		header  []string
+		rows    [][]string
+		lines   []int
+		err     string
+	}{
+		{"csv列名", formatCsv, "\ufeff id ,url\n1,a\n ,\n2,b\n", []string{"id", "url"}, [][]string{{"1", "a"}, {"2", "b"}}, []int{2, 4}, ""},
+		{"csv列数不同", formatCsv, "id,url\n1\n2,b,c\n", []string{"id", "url"}, [][]string{{"1"}, {"2", "b", "c"}}, []int{2, 3}, ""},
+		{"csv为空", formatCsv, "", nil, nil, nil, "文件为空"},
+		{"csv列数超限", formatCsv, strings.Repeat("a,", maxImportColumns) + "a\n", nil, nil, nil, "列数不能超过"},
+		{"yaml", "yml", "- id: 1\n  url: a\n- id: 2\n  desc: ~\n", []string{"desc", "id", "url"}, [][]string{{"", "1", "a"}, {"", "2", ""}}, []int{1, 2}, ""},
+		{"yaml为空", formatYaml, "[]", nil, nil, nil, "文件为空"},
+		{"xlsx共享字符串", formatXlsx, xlsxFile(t, map[string]string{
+			"xl/sharedStrings.xml": sharedStrings,
+			"xl/worksheets/sheet1.xml": sheetXml(`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>` +
+				`<row r="3"><c r="A3"><v>7</v></c><c r="B3" t="s"><v>2</v></c></row>`),
+		}), []string{"id", "url"}, [][]string{{"7", `a\.com`}}, []int{3}, ""},
+		{"xlsx空单元格", formatXlsx, xlsxFile(t, map[string]string{
+			"xl/worksheets/sheet1.xml": sheetXml(`<row r="1"><c r="A1" t="inlineStr"><is><t>id</t></is></c><c r="C1" t="inlineStr"><is><t>url</t></is></c></row>` +
+				`<row r="2"><c r="C2" t="inlineStr"><is><t>x</t></is></c></row><row r="3"><c r="A3"><v></v></c></row>`),
+		}), []string{"id", "", "url"}, [][]string{{"", "", "x"}}, []int{2}, ""},
+		{"xlsx按工作簿取第一个工作表", formatXlsx, xlsxFile(t, map[string]string{
+			"xl/workbook.xml": workbook, "xl/_rels/workbook.xml.rels": rels,
+			"xl/worksheets/sheet1.xml": sheetXml(`<row r="1"><c r="A1"><v>1</v></c></row>`),
+			"xl/worksheets/sheet2.xml": sheetXml(`<row r="1"><c r="A1"><v>2</v></c></row>`),
+		}), []string{"2"}, nil, nil, ""},
+		{"xlsx坐标缺少列", formatXlsx, xlsxFile(t, map[string]string{
+			"xl/worksheets/sheet1.xml": sheetXml(`<row r="1"><c r="1"><v>id</v></c><c><v>url</v></c></row><row r="2"><c r="2"><v>1</v></c><c r="B2" t="s"><v>9</v></c></row>`),
+		}), []string{"id", "url"}, [][]string{{"1", ""}}, nil, ""},
+		{"xlsx列超限", formatXlsx, xlsxFile(t, map[string]string{
+			"xl/worksheets/sheet1.xml": sheetXml(`<row r="1"><c r="XFD1"><v>id</v></c></row>`),
+		}), nil, nil, nil, "超出列数上限"},
+		{"xlsx格式错误", formatXlsx, "not a zip", nil, nil, nil, "xlsx文件格式错误"},
+		{"不支持的格式", "json", "[]", nil, nil, nil, "不支持的文件格式"},
+	}
+	for _, c := range cases {
+		table, err := ReadImportFile(c.format, strings.NewReader(c.content))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(table.Header, c.header) || !reflect.DeepEqual(table.Rows, c.rows) || (c.lines != nil && !reflect.DeepEqual(table.Lines, c.lines)) {
+			t.Errorf("%s: got %+v", c.name, table)
+		}
+	}
+}
+
+func TestReadImportFileSize(t *testing.T) {
+	// xlsx先读取整个文件再解压，超限时报大小错误，未超限时报格式错误
+	content := strings.Repeat("x", MaxImportSize+1)
+	if _, err := ReadImportFile(formatXlsx, strings.NewReader(content)); err == nil || !strings.Contains(err.Error(), "文件不能超过") {
+		t.Fatalf("expected size error, got %v", err)
+	}
+	if _, err := ReadImportFile(formatXlsx, strings.NewReader(content[1:])); err == nil || err.Error() != "xlsx文件格式错误" {
+		t.Fatalf("expected file within limit to be read, got %v", err)
+	}
+}
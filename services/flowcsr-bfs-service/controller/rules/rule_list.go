@@ -0,0 +1,383 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 同时查询的规则库数量
+const repoParallel = 4
+
+// 分页大小上限，规则列表、搜索共用
+const maxPageSize = 500
+
+// 列表快照保留时间及数量上限，翻页时复用首页的查询结果，不再查询整库
+const (
+	listSnapshotTTL  = 5 * time.Minute
+	maxListSnapshots = 100
+)
+
+// 排序字段，前缀"-"为倒序
+var listSorts = []string{"rule_id", "priority", "status", "line_num"}
+
+// RuleList 规则分页列表，RepoIds为空时查询有权限的全部规则库
+type RuleList struct {
+	RepoIds  []int      `json:"repo_ids"`
+	Filter   RuleFilter `json:"filter"`
+	Sort     string     `json:"sort"` // 默认按规则库、规则id
+	PageSize int        `json:"page_size"`
+	Cursor   string     `json:"cursor"` // 上一页返回的next_cursor，快照有效期内翻页不再查询上游
+}
+
+// RuleFilter 规则过滤条件，零值不过滤
+type RuleFilter struct {
+	Status      *int   `json:"status"`
+	PriorityMin *int   `json:"priority_min"`
+	PriorityMax *int   `json:"priority_max"`
+	DimensionId int    `json:"dimension_id"`
+	TagId       int    `json:"tag_id"`
+	Desc        string `json:"desc"`   // 子串匹配
+	Sample      string `json:"sample"` // 子串匹配
+	ParserId    int    `json:"parser_id"`
+}
+
+type RuleListItem struct {
+	RepoId     int                    `json:"repo_id"`
+	RuleId     int                    `json:"rule_id"`
+	LineNum    int                    `json:"line_num"`
+	ParserId   int                    `json:"parser_id"`
+	Pattern    map[string]string      `json:"pattern"`
+	Attributes response.Attribute     `json:"attributes"`
+	Dimensions []public.RuleDimension `json:"dimensions"`
+}
+
+type RuleListPage struct {
+	List       []RuleListItem `json:"list"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor"` // 为空时没有下一页
+}
+
+// listCursor 上一页最后一条的排序位置，Snapshot为首页查询结果的快照id
+type listCursor struct {
+	Value    int    `json:"v"`
+	RepoId   int    `json:"r"`
+	RuleId   int    `json:"i"`
+	LineNum  int    `json:"l"`
+	Snapshot string `json:"s,omitempty"`
+}
+
+// listSnapshot 过滤、排序后的列表，只给同一调用方、同一查询条件翻页使用
+type listSnapshot struct {
+	owner     string
+	query     string
+	repoIds   []int
+	items     []RuleListItem
+	expiresAt time.Time
+}
+
+var listSnapshots = struct {
+	sync.Mutex
+	m map[string]*listSnapshot
+}{m: map[string]*listSnapshot{}}
+
+func (This RuleList) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则列表")
+	if This.PageSize <= 0 {
+		This.PageSize = 20
+	}
+	if This.PageSize > maxPageSize {
+		return ico.Err(2099, fmt.Sprintf("page_size不能大于%d", maxPageSize))
+	}
+	sortField := strings.TrimPrefix(This.Sort, "-")
+	if This.Sort != "" && !isListSort(sortField) {
+		return ico.Err(2099, "排序字段异常")
+	}
+	var after *listCursor
+	if This.Cursor != "" {
+		cursor, err := decodeCursor(This.Cursor)
+		if err != nil {
+			return ico.Err(2099, "cursor异常")
+		}
+		after = &cursor
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	// 1.翻页时优先使用快照，快照过期或条件不一致时重新查询
+	owner := ReservationOwner(token)
+	query := This.query()
+	var items []RuleListItem
+	var repoIds []int
+	snapshot := ""
+	if after != nil && after.Snapshot != "" {
+		if cached, ok := loadListSnapshot(after.Snapshot, owner, query, permissionToken.RepoIds, time.Now()); ok {
+			items, snapshot = cached, after.Snapshot
+		}
+	}
+	if snapshot == "" {
+		repoIds, items, code, err = This.fetch(token, permissionToken.RepoIds)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		This.sort(items)
+	}
+	// 2.分页
+	res := RuleListPage{List: []RuleListItem{}, Total: len(items)}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(items), func(i int) bool { return This.less(*after, This.cursorOf(items[i])) })
+	}
+	end := start + This.PageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	res.List = append(res.List, items[start:end]...)
+	if end < len(items) {
+		if snapshot == "" {
+			snapshot = saveListSnapshot(&listSnapshot{owner: owner, query: query, repoIds: repoIds, items: items}, time.Now())
+		}
+		cursor := This.cursorOf(items[end-1])
+		cursor.Snapshot = snapshot
+		res.NextCursor = encodeCursor(cursor)
+	}
+	return ico.Succ(res)
+}
+
+// fetch 并发查询有权限的规则库并过滤
+func (This RuleList) fetch(token string, permitted []int) (repoIds []int, items []RuleListItem, code int, err error) {
+	// 1.规则库
+	repoIds = []int{}
+	if len(This.RepoIds) == 0 {
+		repoDataList, code, err := public.GetRepos(token, nil)
+		if err != nil {
+			return repoIds, nil, code, err
+		}
+		for _, repoData := range repoDataList.List {
+			if utils.IsContainsInt(permitted, repoData.RepoId) {
+				repoIds = append(repoIds, repoData.RepoId)
+			}
+		}
+	} else {
+		for _, repoId := range This.RepoIds {
+			if !utils.IsContainsInt(permitted, repoId) {
+				return repoIds, nil, 2007, errors.New("权限不足")
+			}
+		}
+		repoIds = This.RepoIds
+	}
+	// 2.并发查询各规则库并过滤
+	repoItems := make([][]RuleListItem, len(repoIds))
+	codes := make([]int, len(repoIds))
+	errs := make([]error, len(repoIds))
+	sem := make(chan struct{}, repoParallel)
+	wg := sync.WaitGroup{}
+	for i, repoId := range repoIds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, repoId int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ruleDataList, code, err := public.GetRepoRules(token, repoId)
+			if err != nil {
+				codes[i], errs[i] = code, err
+				return
+			}
+			for _, ruleData := range ruleDataList.List {
+				if This.Filter.Match(ruleData) {
+					repoItems[i] = append(repoItems[i], NewRuleListItem(repoId, ruleData))
+				}
+			}
+		}(i, repoId)
+	}
+	wg.Wait()
+	items = []RuleListItem{}
+	for i := range repoIds {
+		if errs[i] != nil {
+			return repoIds, nil, codes[i], errs[i]
+		}
+		items = append(items, repoItems[i]...)
+	}
+	return
+}
+
+// query 查询条件，快照只在条件一致时复用
+func (This RuleList) query() string {
+	b, _ := json.Marshal(map[string]interface{}{"repo_ids": This.RepoIds, "filter": This.Filter, "sort": This.Sort})
+	return string(b)
+}
+
+// saveListSnapshot 保存快照并返回快照id，先清理过期的，超出上限时淘汰最早过期的
+func saveListSnapshot(snapshot *listSnapshot, now time.Time) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	snapshot.expiresAt = now.Add(listSnapshotTTL)
+	listSnapshots.Lock()
+	defer listSnapshots.Unlock()
+	oldest := ""
+	for key, s := range listSnapshots.m {
+		if !now.Before(s.expiresAt) {
+			delete(listSnapshots.m, key)
+			continue
+		}
+		if oldest == "" || s.expiresAt.Before(listSnapshots.m[oldest].expiresAt) {
+			oldest = key
+		}
+	}
+	if len(listSnapshots.m) >= maxListSnapshots {
+		delete(listSnapshots.m, oldest)
+	}
+	listSnapshots.m[id] = snapshot
+	return id
+}
+
+// loadListSnapshot 同一调用方、同一条件且规则库仍有权限时返回快照中的列表
+func loadListSnapshot(id, owner, query string, permitted []int, now time.Time) (items []RuleListItem, ok bool) {
+	listSnapshots.Lock()
+	defer listSnapshots.Unlock()
+	snapshot, ok := listSnapshots.m[id]
+	if !ok || snapshot.owner != owner || snapshot.query != query || !now.Before(snapshot.expiresAt) {
+		return nil, false
+	}
+	for _, repoId := range snapshot.repoIds {
+		if !utils.IsContainsInt(permitted, repoId) {
+			return nil, false
+		}
+	}
+	return snapshot.items, true
+}
+
+// IsEmpty 未指定任何过滤条件
+func (f RuleFilter) IsEmpty() bool {
+	return f == RuleFilter{}
+}
+
+// Match 规则是否满足过滤条件
+func (f RuleFilter) Match(rule public.RuleInfo) bool {
+	attr := rule.Attribute()
+	if f.Status != nil && attr.Status != *f.Status {
+		return false
+	}
+	if f.PriorityMin != nil && attr.Priority < *f.PriorityMin {
+		return false
+	}
+	if f.PriorityMax != nil && attr.Priority > *f.PriorityMax {
+		return false
+	}
+	if f.Desc != "" && !strings.Contains(attr.Desc, f.Desc) {
+		return false
+	}
+	if f.Sample != "" && !strings.Contains(attr.Sample, f.Sample) {
+		return false
+	}
+	if f.ParserId != 0 && rule.ParserMessage.ParserId != f.ParserId {
+		return false
+	}
+	if f.DimensionId == 0 && f.TagId == 0 {
+		return true
+	}
+	for _, dim := range rule.Dimensions {
+		if (f.DimensionId == 0 || dim.DimensionId == f.DimensionId) && (f.TagId == 0 || dim.TagId == f.TagId) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewRuleListItem(repoId int, rule public.RuleInfo) RuleListItem {
+	item := RuleListItem{
+		RepoId: repoId, RuleId: rule.RuleId, LineNum: rule.LineNum, ParserId: rule.ParserMessage.ParserId,
+		Pattern: rule.Pattern, Attributes: rule.Attribute(), Dimensions: rule.Dimensions,
+	}
+	if item.Dimensions == nil {
+		item.Dimensions = []public.RuleDimension{}
+	}
+	return item
+}
+
+func (This RuleList) sort(items []RuleListItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return This.less(This.cursorOf(items[i]), This.cursorOf(items[j]))
+	})
+}
+
+// less 先按排序字段，再按规则库、规则id、行号，保证顺序唯一
+func (This RuleList) less(a, b listCursor) bool {
+	if a.Value != b.Value {
+		if strings.HasPrefix(This.Sort, "-") {
+			return a.Value > b.Value
+		}
+		return a.Value < b.Value
+	}
+	if a.RepoId != b.RepoId {
+		return a.RepoId < b.RepoId
+	}
+	if a.RuleId != b.RuleId {
+		return a.RuleId < b.RuleId
+	}
+	return a.LineNum < b.LineNum
+}
+
+func (This RuleList) cursorOf(item RuleListItem) listCursor {
+	cursor := listCursor{RepoId: item.RepoId, RuleId: item.RuleId, LineNum: item.LineNum}
+	switch strings.TrimPrefix(This.Sort, "-") {
+	case "rule_id":
+		cursor.Value = item.RuleId
+	case "priority":
+		cursor.Value = item.Attributes.Priority
+	case "status":
+		cursor.Value = item.Attributes.Status
+	case "line_num":
+		cursor.Value = item.LineNum
+	}
+	return cursor
+}
+
+func isListSort(field string) bool {
+	for _, s := range listSorts {
+		if s == field {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCursor(cursor listCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor listCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &cursor)
+	return
+}
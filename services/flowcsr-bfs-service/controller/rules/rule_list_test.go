@@ -0,0 +1,47 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListSnapshot(t *testing.T) {
+	now := time.Now()
+	items := []RuleListItem{{RepoId: 1, RuleId: 1}, {RepoId: 1, RuleId: 2}}
+	id := saveListSnapshot(&listSnapshot{owner: "user:a", query: "q", repoIds: []int{1}, items: items}, now)
+	if id == "" {
+		t.Fatal("snapshot id should not be empty")
+	}
+	cases := []struct {
+		name      string
+		owner     string
+		query     string
+		permitted []int
+		at        time.Time
+		ok        bool
+	}{
+		{"同一调用方及条件", "user:a", "q", []int{1, 2}, now, true},
+		{"其他调用方", "user:b", "q", []int{1}, now, false},
+		{"条件不同", "user:a", "other", []int{1}, now, false},
+		{"已无规则库权限", "user:a", "q", []int{2}, now, false},
+		{"已过期", "user:a", "q", []int{1}, now.Add(listSnapshotTTL), false},
+	}
+	for _, c := range cases {
+		got, ok := loadListSnapshot(id, c.owner, c.query, c.permitted, c.at)
+		if ok != c.ok || (ok && len(got) != len(items)) {
+			t.Errorf("%s: ok = %v, items = %d", c.name, ok, len(got))
+		}
+	}
+
+	// 超出上限时淘汰最早过期的
+	first := saveListSnapshot(&listSnapshot{owner: "user:a", query: "q"}, now.Add(-time.Minute))
+	for i := 0; i < maxListSnapshots; i++ {
+		saveListSnapshot(&listSnapshot{owner: "user:a", query: "q"}, now)
+	}
+	if _, ok := loadListSnapshot(first, "user:a", "q", nil, now); ok {
+		t.Fatal("oldest snapshot should be evicted")
+	}
+	if len(listSnapshots.m) > maxListSnapshots {
+		t.Fatalf("snapshots = %d, want at most %d", len(listSnapshots.m), maxListSnapshots)
+	}
+}
@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// isEnabled 是否为配置的启用状态，停用规则不参与匹配
+func isEnabled(status int) bool {
+	return status == config.RuleConfig.Enabled
+}
+
+// MatchedRule 命中的规则，Groups为各规则体的捕获分组（下标0为整体匹配）
+type MatchedRule struct {
+	RuleId     int                    `json:"rule_id"`
+	LineNum    int                    `json:"line_num"`
+	Priority   int                    `json:"priority"`
+	Groups     map[string][]string    `json:"groups"`
+	Named      map[string]string      `json:"named"`
+	Dimensions []public.RuleDimension `json:"dimensions"`
+}
+
+// MatchResult 单条输入的匹配结果
+type MatchResult struct {
+	Input    string                 `json:"input"`
+	Matched  []MatchedRule          `json:"matched"`
+	Winner   *MatchedRule           `json:"winner"`
+	Tags     []public.RuleDimension `json:"tags"`
+	Disabled []int                  `json:"disabled"` // 命中但已停用的规则
+}
+
+type compiledRule struct {
+	rule     public.RuleInfo
+	priority int
+	enabled  bool
+	patterns map[string]*regexp.Regexp
+}
+
+// Matcher 本地规则匹配，规则体各项均命中时规则命中；优先级数值越大越优先，相同时取规则id小者
+type Matcher struct {
+	rules []compiledRule
+}
+
+// NewMatcher 编译规则体，正则错误的规则返回在errs中且不参与匹配
+func NewMatcher(rules []public.RuleInfo) (m *Matcher, errs []RuleError) {
+	m = &Matcher{}
+	for i, rule := range rules {
+		attr := rule.Attribute()
+		compiled := compiledRule{rule: rule, priority: attr.Priority, enabled: isEnabled(attr.Status), patterns: map[string]*regexp.Regexp{}}
+		var messages []string
+		for key, pattern := range rule.Pattern {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				messages = append(messages, fmt.Sprintf("规则体[%s]正则错误：%s", key, err.Error()))
+				continue
+			}
+			compiled.patterns[key] = re
+		}
+		if len(messages) != 0 {
+			errs = append(errs, RuleError{Index: i, Id: rule.RuleId, Errors: messages})
+			continue
+		}
+		if len(compiled.patterns) == 0 {
+			continue
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	sort.SliceStable(m.rules, func(i, j int) bool {
+		if m.rules[i].priority != m.rules[j].priority {
+			return m.rules[i].priority > m.rules[j].priority
+		}
+		return m.rules[i].rule.RuleId < m.rules[j].rule.RuleId
+	})
+	return
+}
+
+// Match 匹配单条输入，Matched按优先级排序，Winner为首条启用规则
+func (m *Matcher) Match(input string) MatchResult {
+	result := MatchResult{Input: input, Matched: []MatchedRule{}, Tags: []public.RuleDimension{}, Disabled: []int{}}
+	for _, compiled := range m.rules {
+		matched, ok := compiled.match(input)
+		if !ok {
+			continue
+		}
+		if !compiled.enabled {
+			result.Disabled = append(result.Disabled, compiled.rule.RuleId)
+			continue
+		}
+		result.Matched = append(result.Matched, matched)
+	}
+	if len(result.Matched) != 0 {
+		result.Winner = &result.Matched[0]
+		result.Tags = result.Winner.Dimensions
+	}
+	return result
+}
+
+func (c compiledRule) match(input string) (matched MatchedRule, ok bool) {
+	matched = MatchedRule{
+		RuleId: c.rule.RuleId, LineNum: c.rule.LineNum, Priority: c.priority,
+		Groups: map[string][]string{}, Named: map[string]string{}, Dimensions: c.rule.Dimensions,
+	}
+	for key, re := range c.patterns {
+		groups := re.FindStringSubmatch(input)
+		if groups == nil {
+			return matched, false
+		}
+		matched.Groups[key] = groups
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				matched.Named[name] = groups[i]
+			}
+		}
+	}
+	if matched.Dimensions == nil {
+		matched.Dimensions = []public.RuleDimension{}
+	}
+	return matched, true
+}
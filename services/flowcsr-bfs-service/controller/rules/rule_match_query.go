@@ -0,0 +1,50 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+type RuleTest struct {
+	RepoId int      `json:"repo_id" binding:"required"`
+	Inputs []string `json:"inputs" binding:"required"`
+}
+
+type RuleTestRes struct {
+	List   []MatchResult `json:"list"`
+	Errors []RuleError   `json:"errors"` // 规则体无法编译、未参与匹配的规则
+}
+
+func (This RuleTest) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则匹配测试")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 1.规则库全部规则
+	ruleDataList, code, err := public.GetRepoRules(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	// 2.本地匹配
+	matcher, ruleErrors := NewMatcher(ruleDataList.List)
+	res := RuleTestRes{List: []MatchResult{}, Errors: ruleErrors}
+	for _, input := range This.Inputs {
+		res.List = append(res.List, matcher.Match(input))
+	}
+	return ico.Succ(res)
+}
@@ -0,0 +1,41 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	rules := []public.RuleInfo{
+		{RuleId: 1, Pattern: map[string]string{"url": `(\w+)\.example\.com`}, Attributes: response.AttributeRes{Priority: "10", Status: "1"},
+			Dimensions: []public.RuleDimension{{TagId: 100, DimensionId: 1}}},
+		{RuleId: 2, Pattern: map[string]string{"url": `(?P<sub>api)\.example`}, Attributes: response.AttributeRes{Priority: "20", Status: "1"},
+			Dimensions: []public.RuleDimension{{TagId: 200, DimensionId: 1}}},
+		{RuleId: 3, Pattern: map[string]string{"url": `example`}, Attributes: response.AttributeRes{Priority: "90", Status: "0"}},
+		{RuleId: 4, Pattern: map[string]string{"url": `(`}, Attributes: response.AttributeRes{Priority: "1", Status: "1"}},
+	}
+	matcher, errs := NewMatcher(rules)
+	if len(errs) != 1 || errs[0].Id != 4 {
+		t.Fatalf("expected rule 4 to fail compiling, got %v", errs)
+	}
+
+	result := matcher.Match("api.example.com")
+	if len(result.Matched) != 2 || result.Winner == nil || result.Winner.RuleId != 2 {
+		t.Fatalf("expected rule 2 to win over rule 1, got %+v", result)
+	}
+	if result.Winner.Named["sub"] != "api" || result.Matched[1].Groups["url"][1] != "api" {
+		t.Fatalf("unexpected groups %+v", result.Matched)
+	}
+	if len(result.Tags) != 1 || result.Tags[0].TagId != 200 {
+		t.Fatalf("unexpected tags %+v", result.Tags)
+	}
+	if len(result.Disabled) != 1 || result.Disabled[0] != 3 {
+		t.Fatalf("expected disabled rule 3, got %v", result.Disabled)
+	}
+
+	result = matcher.Match("nothing")
+	if len(result.Matched) != 0 || result.Winner != nil {
+		t.Fatalf("expected no match, got %+v", result)
+	}
+}
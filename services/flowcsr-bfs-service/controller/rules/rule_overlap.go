@@ -0,0 +1,211 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 问题类型
+const (
+	findingDupId     = "duplicate_id" // 规则id有多行
+	findingNeverWins = "never_wins"   // 规则体与更高优先级规则相同但标签不同，永远不会命中
+	findingDuplicate = "duplicate"    // 规则体及标签均相同，冗余
+	findingShadowed  = "shadowed"     // 样例被其他规则命中
+	findingOverlap   = "overlap"      // 样例同时命中同优先级的其他规则
+	findingNoSample  = "no_sample"    // 样例为空，无法分析
+)
+
+// RuleOverlap 规则重叠、遮蔽分析，只分析启用的规则
+type RuleOverlap struct {
+	RepoId int `json:"repo_id" binding:"required"`
+}
+
+type RuleOverlapRes struct {
+	RepoId int       `json:"repo_id"`
+	High   []Finding `json:"high"`
+	Medium []Finding `json:"medium"`
+	Low    []Finding `json:"low"`
+}
+
+type Finding struct {
+	Type    string        `json:"type"`
+	Message string        `json:"message"`
+	Rules   []FindingRule `json:"rules"` // 首条为问题规则
+}
+
+type FindingRule struct {
+	RuleId   int                    `json:"rule_id"`
+	LineNum  int                    `json:"line_num"`
+	Priority int                    `json:"priority"`
+	Tags     []public.RuleDimension `json:"tags"`
+}
+
+func (This RuleOverlap) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则重叠分析")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	ruleDataList, code, err := public.GetRepoRules(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	res := Analyze(ruleDataList.List)
+	res.RepoId = This.RepoId
+	return ico.Succ(res)
+}
+
+// ruleLine 规则按(id,行号)区分，重复id的各行分别分析
+type ruleLine struct {
+	RuleId  int
+	LineNum int
+}
+
+func lineOf(ruleId, lineNum int) ruleLine {
+	return ruleLine{RuleId: ruleId, LineNum: lineNum}
+}
+
+// Analyze 按规则体比较及样例匹配找出重复、遮蔽、重叠的规则
+func Analyze(rules []public.RuleInfo) RuleOverlapRes {
+	res := RuleOverlapRes{High: []Finding{}, Medium: []Finding{}, Low: []Finding{}}
+	// 0.重复id，规则id应唯一
+	byId := map[int][]public.RuleInfo{}
+	ids := []int{}
+	for _, rule := range rules {
+		if _, ok := byId[rule.RuleId]; !ok {
+			ids = append(ids, rule.RuleId)
+		}
+		byId[rule.RuleId] = append(byId[rule.RuleId], rule)
+	}
+	for _, id := range ids {
+		if len(byId[id]) > 1 {
+			res.High = append(res.High, Finding{
+				Type: findingDupId, Rules: findingRules(byId[id]...),
+				Message: fmt.Sprintf("规则[%d]有%d行，请先修复重复id", id, len(byId[id])),
+			})
+		}
+	}
+	enabled := []public.RuleInfo{}
+	for _, rule := range rules {
+		if isEnabled(rule.Attribute().Status) {
+			enabled = append(enabled, rule)
+		}
+	}
+	// 与匹配时的先后一致：优先级高在前，相同时规则id小在前，再按行号
+	sort.SliceStable(enabled, func(i, j int) bool {
+		pi, pj := enabled[i].Attribute().Priority, enabled[j].Attribute().Priority
+		if pi != pj {
+			return pi > pj
+		}
+		if enabled[i].RuleId != enabled[j].RuleId {
+			return enabled[i].RuleId < enabled[j].RuleId
+		}
+		return enabled[i].LineNum < enabled[j].LineNum
+	})
+	ruleMap := map[ruleLine]public.RuleInfo{}
+	for _, rule := range enabled {
+		ruleMap[lineOf(rule.RuleId, rule.LineNum)] = rule
+	}
+	// 1.规则体相同，排在后面的规则永远不会命中
+	neverWins := map[ruleLine]bool{}
+	groups := map[string][]public.RuleInfo{}
+	keys := []string{}
+	for _, rule := range enabled {
+		key := patternKey(rule.Pattern)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], rule)
+	}
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		first := group[0]
+		for _, rule := range group[1:] {
+			neverWins[lineOf(rule.RuleId, rule.LineNum)] = true
+			if !sameTags(first, rule) {
+				res.High = append(res.High, Finding{
+					Type: findingNeverWins, Rules: findingRules(rule, first),
+					Message: fmt.Sprintf("规则[%d]与规则[%d]规则体相同但标签不同，且优先级不高于后者，永远不会命中", rule.RuleId, first.RuleId),
+				})
+				continue
+			}
+			res.Medium = append(res.Medium, Finding{
+				Type: findingDuplicate, Rules: findingRules(rule, first),
+				Message: fmt.Sprintf("规则[%d]与规则[%d]规则体及标签相同，可删除", rule.RuleId, first.RuleId),
+			})
+		}
+	}
+	// 2.样例匹配
+	matcher, _ := NewMatcher(enabled)
+	for _, rule := range enabled {
+		attr := rule.Attribute()
+		if attr.Sample == "" {
+			res.Low = append(res.Low, Finding{Type: findingNoSample, Rules: findingRules(rule), Message: fmt.Sprintf("规则[%d]样例为空", rule.RuleId)})
+			continue
+		}
+		self := lineOf(rule.RuleId, rule.LineNum)
+		if neverWins[self] {
+			continue
+		}
+		result := matcher.Match(attr.Sample)
+		if result.Winner != nil && lineOf(result.Winner.RuleId, result.Winner.LineNum) != self {
+			winner := ruleMap[lineOf(result.Winner.RuleId, result.Winner.LineNum)]
+			finding := Finding{
+				Type: findingShadowed, Rules: findingRules(rule, winner),
+				Message: fmt.Sprintf("规则[%d]的样例被规则[%d]命中", rule.RuleId, winner.RuleId),
+			}
+			if sameTags(rule, winner) {
+				res.Medium = append(res.Medium, finding)
+			} else {
+				res.High = append(res.High, finding)
+			}
+			continue
+		}
+		for _, matched := range result.Matched {
+			if lineOf(matched.RuleId, matched.LineNum) != self && matched.Priority == attr.Priority {
+				other := ruleMap[lineOf(matched.RuleId, matched.LineNum)]
+				res.Medium = append(res.Medium, Finding{
+					Type: findingOverlap, Rules: findingRules(rule, other),
+					Message: fmt.Sprintf("规则[%d]的样例同时命中同优先级的规则[%d]", rule.RuleId, other.RuleId),
+				})
+			}
+		}
+	}
+	return res
+}
+
+func findingRules(rules ...public.RuleInfo) []FindingRule {
+	list := []FindingRule{}
+	for _, rule := range rules {
+		tags := rule.Dimensions
+		if tags == nil {
+			tags = []public.RuleDimension{}
+		}
+		list = append(list, FindingRule{RuleId: rule.RuleId, LineNum: rule.LineNum, Priority: rule.Attribute().Priority, Tags: tags})
+	}
+	return list
+}
+
+func sameTags(a, b public.RuleInfo) bool {
+	return reflect.DeepEqual(tagsOf(a), tagsOf(b))
+}
@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	rule := func(id, line int, url, priority, sample string, tagId int) public.RuleInfo {
+		return public.RuleInfo{RuleId: id, LineNum: line, Pattern: map[string]string{"url": url},
+			Attributes: response.AttributeRes{Priority: priority, Status: "1", Sample: sample},
+			Dimensions: []public.RuleDimension{{DimensionId: 1, TagId: tagId}}}
+	}
+	cases := []struct {
+		name   string
+		rules  []public.RuleInfo
+		high   []string
+		medium []string
+		low    []string
+	}{
+		{"无问题", []public.RuleInfo{rule(1, 1, "a", "1", "a", 1), rule(2, 2, "b", "1", "b", 2)}, nil, nil, nil},
+		{"规则体相同标签不同", []public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(2, 2, "a", "1", "a", 2)}, []string{findingNeverWins}, nil, nil},
+		{"规则体及标签相同", []public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(2, 2, "a", "1", "a", 1)}, nil, []string{findingDuplicate}, nil},
+		{"样例被遮蔽", []public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(2, 2, "ab", "1", "ab", 2)}, []string{findingShadowed}, nil, nil},
+		{"同优先级重叠", []public.RuleInfo{rule(1, 1, "b", "1", "ab", 1), rule(2, 2, "ab", "1", "ab", 1)}, nil, []string{findingOverlap, findingShadowed}, nil},
+		{"样例为空", []public.RuleInfo{rule(1, 1, "a", "1", "", 1)}, nil, nil, []string{findingNoSample}},
+		// 重复id的两行分别分析，不会互相覆盖
+		{"重复id", []public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(1, 2, "a", "1", "a", 2)}, []string{findingDupId, findingNeverWins}, nil, nil},
+		{"重复id样例被另一行遮蔽", []public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(1, 2, "ab", "1", "ab", 2)}, []string{findingDupId, findingShadowed}, nil, nil},
+	}
+	types := func(findings []Finding) (list []string) {
+		for _, finding := range findings {
+			list = append(list, finding.Type)
+		}
+		return
+	}
+	for _, c := range cases {
+		res := Analyze(c.rules)
+		if !reflect.DeepEqual(types(res.High), c.high) || !reflect.DeepEqual(types(res.Medium), c.medium) || !reflect.DeepEqual(types(res.Low), c.low) {
+			t.Errorf("%s: got high %v medium %v low %v", c.name, types(res.High), types(res.Medium), types(res.Low))
+		}
+	}
+	res := Analyze([]public.RuleInfo{rule(1, 1, "a", "2", "a", 1), rule(1, 2, "ab", "1", "ab", 2)})
+	if rules := res.High[1].Rules; rules[0].LineNum != 2 || rules[1].LineNum != 1 {
+		t.Errorf("expected line 2 shadowed by line 1, got %+v", rules)
+	}
+}
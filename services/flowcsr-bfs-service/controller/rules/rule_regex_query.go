@@ -3,23 +3,67 @@ package rules
 import (
 	"bigrule/common/ico"
 	"bigrule/common/logger"
+	"bigrule/pkg/utils"
 	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"regexp"
 	"strings"
 )
 
+// 分页方式
+const (
+	regexPaged   = 0 // 按page_size、page_index分页
+	regexUnpaged = 1 // 不分页，limit大于0时只返回前limit条，为0时返回全部
+)
+
+// 返回的规则字段，与上游规则查询的data_type一致，规则id、行号总是返回
+const (
+	regexDataAll       = 0 // 属性、规则体、维度、解析规则
+	regexDataPattern   = 1 // 属性、规则体
+	regexDataDimension = 2 // 属性、维度
+	regexDataParser    = 3 // 解析规则
+)
+
+// 匹配方式
+const (
+	matchExact    = "exact"
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+	matchRegex    = "regex"
+)
+
+// 上游只支持区分大小写的正则匹配，其他方式在本地按规则库全量规则匹配
+var upstreamModes = []string{matchRegex}
+
+// RuleRegexQuery 规则体查询，Match与RegexLike二选一，RegexLike原样转发给上游，由上游校验
 type RuleRegexQuery struct {
 	Type      int         `json:"type"`       // 是否分页（0：分页；1：不分页）
-	DataType  int         `json:"data_type"`  // 1：attributes+pattern
-	PageSize  int         `json:"page_size"`  // 分页选填
-	PageIndex int         `json:"page_index"` // 分页选填
-	Limit     int         `json:"limit"`      // 不分页选填
+	DataType  int         `json:"data_type"`  // 返回的规则字段（0：全部；1：属性+规则体；2：属性+维度；3：解析规则）
+	PageSize  int         `json:"page_size"`  // 分页选填，默认20
+	PageIndex int         `json:"page_index"` // 分页选填，默认1
+	Limit     int         `json:"limit"`      // 不分页选填，只返回前limit条，0为全部
 	RepoId    int         `json:"repo_id"`
 	RuleIds   []int       `json:"rule_ids"`
-	RegexLike interface{} `json:"regex_like"`
+	RegexLike interface{} `json:"regex_like"` // 规则体key -> 正则
+	Match     *RegexMatch `json:"match"`
+}
+
+// RegexMatch 规则体单个key的匹配条件
+type RegexMatch struct {
+	Key           string `json:"key"              binding:"required"`
+	Value         string `json:"value"            binding:"required"`
+	Mode          string `json:"mode"`           // exact、prefix、contains、regex，默认contains
+	CaseSensitive bool   `json:"case_sensitive"` // 默认不区分大小写
+}
+
+// RegexQueryPage 本地匹配的结果，List按data_type只保留对应字段
+type RegexQueryPage struct {
+	List  []map[string]interface{} `json:"list"`
+	Total int                      `json:"total"`
 }
 
 func (This RuleRegexQuery) DoHandle(c *gin.Context) *ico.Result {
@@ -29,11 +73,124 @@ func (This RuleRegexQuery) DoHandle(c *gin.Context) *ico.Result {
 	token := c.GetHeader("X-Access-Token")
 	token = strings.Split(token, ";")[0]
 	logger.Info("规则体查询")
-	repoDataList, code, err := This.GetRuleRegexData(token)
+	// 0.校验入参
+	if This.Type != regexPaged && This.Type != regexUnpaged {
+		return ico.Err(2099, fmt.Sprintf("type需为%d或%d", regexPaged, regexUnpaged))
+	}
+	if This.DataType < regexDataAll || This.DataType > regexDataParser {
+		return ico.Err(2099, fmt.Sprintf("data_type需在%d-%d之间", regexDataAll, regexDataParser))
+	}
+	if This.Match != nil && This.RegexLike != nil {
+		return ico.Err(2099, "match与regex_like不能同时指定")
+	}
+	if This.Match == nil {
+		repoDataList, code, err := This.GetRuleRegexData(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		return ico.Succ(repoDataList)
+	}
+	if This.Match.Mode == "" {
+		This.Match.Mode = matchContains
+	}
+	re, err := This.Match.Compile()
+	if err != nil {
+		return ico.Err(2099, "正则错误", err.Error())
+	}
+	// 1.上游支持的匹配方式直接转发
+	if This.Match.CaseSensitive && utils.IsContainsStr(upstreamModes, This.Match.Mode) {
+		This.RegexLike = map[string]string{This.Match.Key: This.Match.Value}
+		repoDataList, code, err := This.GetRuleRegexData(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		return ico.Succ(repoDataList)
+	}
+	// 2.本地匹配
+	if This.RepoId == 0 {
+		return ico.Err(2099, "本地匹配需指定repo_id")
+	}
+	var ruleDataList public.RuleListRes
+	var code int
+	if len(This.RuleIds) != 0 {
+		ruleDataList, code, err = public.GetRules(token, This.RepoId, This.RuleIds)
+	} else {
+		ruleDataList, code, err = public.GetRepoRules(token, This.RepoId)
+	}
 	if err != nil {
 		return ico.Err(code, err.Error())
 	}
-	return ico.Succ(repoDataList)
+	matched := []public.RuleInfo{}
+	for _, ruleData := range ruleDataList.List {
+		if value, ok := ruleData.Pattern[This.Match.Key]; ok && re.MatchString(value) {
+			matched = append(matched, ruleData)
+		}
+	}
+	return ico.Succ(This.Page(matched))
+}
+
+// Compile 各匹配方式统一转换为正则
+func (m RegexMatch) Compile() (*regexp.Regexp, error) {
+	var expr string
+	switch m.Mode {
+	case matchExact:
+		expr = "^" + regexp.QuoteMeta(m.Value) + "$"
+	case matchPrefix:
+		expr = "^" + regexp.QuoteMeta(m.Value)
+	case matchContains:
+		expr = regexp.QuoteMeta(m.Value)
+	case matchRegex:
+		expr = m.Value
+	default:
+		return nil, fmt.Errorf("不支持的匹配方式：%s", m.Mode)
+	}
+	if !m.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// Page 与上游一致的分页方式
+func (This RuleRegexQuery) Page(rules []public.RuleInfo) RegexQueryPage {
+	page := RegexQueryPage{List: []map[string]interface{}{}, Total: len(rules)}
+	start, end := 0, len(rules)
+	if This.Type == regexPaged {
+		if This.PageSize <= 0 {
+			This.PageSize = 20
+		}
+		if This.PageIndex <= 0 {
+			This.PageIndex = 1
+		}
+		start = (This.PageIndex - 1) * This.PageSize
+		end = start + This.PageSize
+	} else if This.Limit > 0 {
+		end = This.Limit
+	}
+	if end > len(rules) {
+		end = len(rules)
+	}
+	for i := start; i < end; i++ {
+		page.List = append(page.List, This.Fields(rules[i]))
+	}
+	return page
+}
+
+// Fields 按data_type保留规则字段
+func (This RuleRegexQuery) Fields(rule public.RuleInfo) map[string]interface{} {
+	item := map[string]interface{}{"rule_id": rule.RuleId, "line_num": rule.LineNum}
+	if This.DataType == regexDataAll || This.DataType == regexDataPattern || This.DataType == regexDataDimension {
+		item["attributes"] = rule.Attributes
+	}
+	if This.DataType == regexDataAll || This.DataType == regexDataPattern {
+		item["pattern"] = rule.Pattern
+	}
+	if This.DataType == regexDataAll || This.DataType == regexDataDimension {
+		item["dimensions"] = rule.Dimensions
+	}
+	if This.DataType == regexDataAll || This.DataType == regexDataParser {
+		item["parser_message"] = rule.ParserMessage
+	}
+	return item
 }
 
 // 识别规则体
@@ -0,0 +1,45 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRegexQueryPage(t *testing.T) {
+	rules := []public.RuleInfo{{RuleId: 1, LineNum: 1}, {RuleId: 2, LineNum: 2}, {RuleId: 3, LineNum: 3}}
+	cases := []struct {
+		name   string
+		query  RuleRegexQuery
+		ids    []int
+		fields []string
+	}{
+		{"默认分页", RuleRegexQuery{}, []int{1, 2, 3}, []string{"attributes", "dimensions", "line_num", "parser_message", "pattern", "rule_id"}},
+		{"第二页", RuleRegexQuery{PageSize: 2, PageIndex: 2, DataType: regexDataPattern}, []int{3}, []string{"attributes", "line_num", "pattern", "rule_id"}},
+		{"超出页数", RuleRegexQuery{PageSize: 2, PageIndex: 3}, []int{}, nil},
+		{"不分页limit", RuleRegexQuery{Type: regexUnpaged, Limit: 2, DataType: regexDataDimension}, []int{1, 2}, []string{"attributes", "dimensions", "line_num", "rule_id"}},
+		{"不分页全部", RuleRegexQuery{Type: regexUnpaged, DataType: regexDataParser}, []int{1, 2, 3}, []string{"line_num", "parser_message", "rule_id"}},
+	}
+	for _, c := range cases {
+		page := c.query.Page(rules)
+		ids := []int{}
+		for _, item := range page.List {
+			ids = append(ids, item["rule_id"].(int))
+		}
+		if page.Total != len(rules) || !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%s: got %+v", c.name, page)
+			continue
+		}
+		if len(page.List) != 0 {
+			fields := []string{}
+			for k := range page.List[0] {
+				fields = append(fields, k)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, c.fields) {
+				t.Errorf("%s: expected fields %v, got %v", c.name, c.fields, fields)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+type RuleRegression struct {
+	RepoId int `json:"repo_id" binding:"required"`
+}
+
+// RegressItem 样例回归失败项，Winner为实际命中的规则
+type RegressItem struct {
+	RuleId int    `json:"rule_id"`
+	Sample string `json:"sample"`
+	Winner int    `json:"winner,omitempty"`
+}
+
+// RegressReport 样例回归结果
+type RegressReport struct {
+	Unmatched   []RegressItem `json:"unmatched"`    // 样例不再命中自身规则
+	Shadowed    []RegressItem `json:"shadowed"`     // 样例被更高优先级的规则命中
+	EmptySample []int         `json:"empty_sample"` // 样例为空
+	Errors      []RuleError   `json:"errors"`       // 规则体无法编译
+}
+
+func (This RuleRegression) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则样例回归")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	ruleDataList, code, err := public.GetRepoRules(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(Regress(ruleDataList.List))
+}
+
+// Regress 以全部规则匹配每条启用规则的样例
+func Regress(rules []public.RuleInfo) RegressReport {
+	report := RegressReport{Unmatched: []RegressItem{}, Shadowed: []RegressItem{}, EmptySample: []int{}}
+	matcher, errs := NewMatcher(rules)
+	report.Errors = errs
+	for _, rule := range rules {
+		attr := rule.Attribute()
+		if !isEnabled(attr.Status) {
+			continue
+		}
+		if attr.Sample == "" {
+			report.EmptySample = append(report.EmptySample, rule.RuleId)
+			continue
+		}
+		result := matcher.Match(attr.Sample)
+		item := RegressItem{RuleId: rule.RuleId, Sample: attr.Sample}
+		if result.Winner != nil {
+			item.Winner = result.Winner.RuleId
+		}
+		matchedSelf := false
+		for _, matched := range result.Matched {
+			if matched.RuleId == rule.RuleId {
+				matchedSelf = true
+				break
+			}
+		}
+		switch {
+		case !matchedSelf:
+			report.Unmatched = append(report.Unmatched, item)
+		case item.Winner != rule.RuleId:
+			report.Shadowed = append(report.Shadowed, item)
+		}
+	}
+	return report
+}
+
+// IsEmpty 是否全部通过，样例为空不视为失败
+func (r RegressReport) IsEmpty() bool {
+	return len(r.Unmatched) == 0 && len(r.Shadowed) == 0 && len(r.Errors) == 0
+}
+
+// RuleIds 失败项涉及的规则id，含遮蔽其他规则的规则
+func (r RegressReport) RuleIds() (ruleIds []int) {
+	for _, item := range r.Unmatched {
+		ruleIds = append(ruleIds, item.RuleId)
+	}
+	for _, item := range r.Shadowed {
+		ruleIds = append(ruleIds, item.RuleId, item.Winner)
+	}
+	for _, ruleError := range r.Errors {
+		ruleIds = append(ruleIds, ruleError.Id)
+	}
+	return
+}
+
+// Since 去掉base中已存在的失败项，只保留本次变更引入的
+func (r RegressReport) Since(base RegressReport) RegressReport {
+	known := map[int]bool{}
+	for _, item := range base.Unmatched {
+		known[item.RuleId] = true
+	}
+	res := RegressReport{Unmatched: []RegressItem{}, Shadowed: []RegressItem{}, EmptySample: []int{}}
+	broken := map[int]bool{}
+	for _, ruleError := range base.Errors {
+		broken[ruleError.Id] = true
+	}
+	for _, ruleError := range r.Errors {
+		if !broken[ruleError.Id] {
+			res.Errors = append(res.Errors, ruleError)
+		}
+	}
+	for _, item := range r.Unmatched {
+		if !known[item.RuleId] {
+			res.Unmatched = append(res.Unmatched, item)
+		}
+	}
+	shadowed := map[RegressItem]bool{}
+	for _, item := range base.Shadowed {
+		shadowed[item] = true
+	}
+	for _, item := range r.Shadowed {
+		if !shadowed[item] {
+			res.Shadowed = append(res.Shadowed, item)
+		}
+	}
+	empty := map[int]bool{}
+	for _, id := range base.EmptySample {
+		empty[id] = true
+	}
+	for _, id := range r.EmptySample {
+		if !empty[id] {
+			res.EmptySample = append(res.EmptySample, id)
+		}
+	}
+	return res
+}
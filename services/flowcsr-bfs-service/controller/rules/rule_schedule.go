@@ -0,0 +1,201 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/schedule"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"time"
+)
+
+const scheduleTimeLayout = "2006-01-02 15:04:05"
+
+// ScheduleCreate 定时修改规则状态或删除规则，到期后与status、delete接口相同的流程执行
+type ScheduleCreate struct {
+	RepoId    int    `json:"repo_id"      binding:"required"`
+	RuleIds   []int  `json:"rule_ids"     binding:"required,min=1"`
+	Action    string `json:"action"       binding:"required"` // status、delete
+	Status    *int   `json:"status"`                          // action为status时必填
+	TagOp     int    `json:"tag_op"`                          // action为delete时必填，同规则删除
+	ExecuteAt string `json:"execute_at"   binding:"required"` // 2006-01-02 15:04:05
+}
+
+func (This ScheduleCreate) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("定时操作新增")
+	// 1.整理入参
+	s := schedule.Schedule{RepoId: This.RepoId, Action: This.Action}
+	switch This.Action {
+	case schedule.ActionStatus:
+		if This.Status == nil {
+			return ico.Err(2099, "修改状态需指定status")
+		}
+		if err := CheckStatus(*This.Status); err != nil {
+			return ico.Err(2099, err.Error())
+		}
+		if len(This.RuleIds) > maxStatusRules {
+			return ico.Err(2099, fmt.Sprintf("需修改%d条规则，单次最多修改%d条，请缩小范围", len(This.RuleIds), maxStatusRules))
+		}
+		s.Status = *This.Status
+	case schedule.ActionDelete:
+		if This.TagOp == 0 {
+			return ico.Err(2099, "删除需指定tag_op")
+		}
+		s.TagOp = This.TagOp
+	default:
+		return ico.Err(2099, fmt.Sprintf("不支持的操作：%s", This.Action))
+	}
+	executeAt, err := time.ParseInLocation(scheduleTimeLayout, This.ExecuteAt, time.Local)
+	if err != nil {
+		return ico.Err(2099, "execute_at格式错误", err.Error())
+	}
+	if !executeAt.After(time.Now()) {
+		return ico.Err(2099, "execute_at需晚于当前时间")
+	}
+	s.ExecuteAt = executeAt
+	// 2.权限判断，执行时需按创建人身份重新校验，无法校验身份时不能创建
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	caller := middleware.GetCaller(token)
+	if caller.UserName == "" {
+		return ico.Err(2007, "无法校验调用方身份，不能创建定时操作")
+	}
+	// 执行时以创建人token校验权限，token到期后无法执行
+	if !caller.ExpiresAt.IsZero() && executeAt.After(caller.ExpiresAt) {
+		return ico.Err(2099, fmt.Sprintf("execute_at不能晚于token过期时间%s", caller.ExpiresAt.Format(scheduleTimeLayout)))
+	}
+	// 3.规则需存在
+	ruleDataList, code, err := public.GetRules(token, This.RepoId, This.RuleIds)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	found := map[int]bool{}
+	for _, ruleData := range ruleDataList.List {
+		found[ruleData.RuleId] = true
+	}
+	ruleIds := []int{}
+	for _, ruleId := range This.RuleIds {
+		if !found[ruleId] {
+			return ico.Err(2099, fmt.Sprintf("规则[%d]不存在", ruleId))
+		}
+		if !utils.IsContainsInt(ruleIds, ruleId) {
+			ruleIds = append(ruleIds, ruleId)
+		}
+	}
+	// 4.保存
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	audit.AddRefs(c, audit.KindRule, ruleIds...)
+	s.UserId, s.UserName = caller.UserId, caller.UserName
+	if err := schedule.Create(&s, ruleIds, token); err != nil {
+		logger.Error("定时操作保存失败 ", err.Error())
+		return ico.Err(2301, "定时操作保存失败")
+	}
+	return ico.Succ(s)
+}
+
+// scheduledOperation 定时操作执行时在操作名称中记录创建人，提交信息中可见
+func scheduledOperation(c *gin.Context, operation string) string {
+	if creator := middleware.ScheduleCreator(c); creator.UserName != "" {
+		return fmt.Sprintf("%s（定时操作，创建人：%s）", operation, creator.UserName)
+	}
+	return operation
+}
+
+// ScheduleList 定时操作列表，RepoId为空时查询有权限的全部规则库
+type ScheduleList struct {
+	RepoId    int    `json:"repo_id"`
+	State     string `json:"state"` // pending、running、done、failed、cancelled，为空时全部
+	PageSize  int    `json:"page_size"`
+	PageIndex int    `json:"page_index"`
+}
+
+type ScheduleListRes struct {
+	Total int64               `json:"total"`
+	List  []schedule.Schedule `json:"list"`
+}
+
+func (This ScheduleList) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("定时操作查询")
+	if This.PageSize <= 0 {
+		This.PageSize = 20
+	}
+	if This.PageIndex <= 0 {
+		This.PageIndex = 1
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	repoIds := permissionToken.RepoIds
+	if This.RepoId != 0 {
+		if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+			return ico.Err(2007, "权限不足")
+		}
+		repoIds = []int{This.RepoId}
+	}
+	if len(repoIds) == 0 {
+		return ico.Succ(ScheduleListRes{List: []schedule.Schedule{}})
+	}
+	list, total, err := schedule.Query(schedule.Filter{RepoIds: repoIds, State: This.State, PageSize: This.PageSize, PageIndex: This.PageIndex})
+	if err != nil {
+		logger.Error("定时操作查询失败 ", err.Error())
+		return ico.Err(2301, "数据查询失败")
+	}
+	if list == nil {
+		list = []schedule.Schedule{}
+	}
+	return ico.Succ(ScheduleListRes{Total: total, List: list})
+}
+
+// ScheduleCancel 取消待执行的定时操作
+type ScheduleCancel struct {
+	Id int `json:"id"   binding:"required"`
+}
+
+func (This ScheduleCancel) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("定时操作取消")
+	s, err := schedule.Get(This.Id)
+	if err != nil {
+		return ico.Err(2099, "定时操作不存在")
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, s.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	audit.AddRefs(c, audit.KindRepo, s.RepoId)
+	if err := schedule.Cancel(This.Id); err != nil {
+		return ico.Err(2099, err.Error())
+	}
+	s.State = schedule.StateCancelled
+	return ico.Succ(s)
+}
@@ -0,0 +1,244 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 搜索字段
+const (
+	searchPattern = "pattern"
+	searchDesc    = "desc"
+	searchSample  = "sample"
+)
+
+// 命中部分的标记
+const (
+	highlightStart = "<em>"
+	highlightEnd   = "</em>"
+)
+
+// RuleSearch 跨规则库搜索规则体、描述、样例，RepoIds为空时搜索有权限的全部规则库
+type RuleSearch struct {
+	Keyword     string     `json:"keyword"        binding:"required"`
+	Regex       bool       `json:"regex"`        // 为true时keyword为正则，否则为子串
+	IgnoreCase  bool       `json:"ignore_case"`  // 忽略大小写
+	Fields      []string   `json:"fields"`       // pattern、desc、sample，为空时全部
+	PatternKeys []string   `json:"pattern_keys"` // 只搜索规则体中的这些key，为空时全部
+	RepoIds     []int      `json:"repo_ids"`
+	Filter      RuleFilter `json:"filter"`
+	PageSize    int        `json:"page_size"`
+	PageIndex   int        `json:"page_index"`
+}
+
+type RuleSearchRes struct {
+	Total int          `json:"total"`
+	List  []SearchHit  `json:"list"`
+	Repos []SearchRepo `json:"repos"` // 每个规则库的命中数
+}
+
+type SearchHit struct {
+	RuleListItem
+	Matches []SearchMatch `json:"matches"`
+}
+
+// SearchMatch 命中的字段，规则体为pattern.<key>；Ranges为原文中命中的字节区间，
+// Highlight为HTML转义后的原文，以<em></em>标记命中部分
+type SearchMatch struct {
+	Field     string   `json:"field"`
+	Ranges    [][2]int `json:"ranges"`
+	Highlight string   `json:"highlight"`
+}
+
+// SearchRepo 规则库命中数，查询失败时Error不为空
+type SearchRepo struct {
+	RepoId int    `json:"repo_id"`
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (This RuleSearch) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则搜索")
+	for _, field := range This.Fields {
+		if !utils.IsContainsStr([]string{searchPattern, searchDesc, searchSample}, field) {
+			return ico.Err(2099, fmt.Sprintf("不支持的搜索字段：%s", field))
+		}
+	}
+	re, err := This.Compile()
+	if err != nil {
+		return ico.Err(2099, "正则错误", err.Error())
+	}
+	if This.PageSize <= 0 {
+		This.PageSize = 20
+	}
+	if This.PageSize > maxPageSize {
+		return ico.Err(2099, fmt.Sprintf("page_size不能大于%d", maxPageSize))
+	}
+	if This.PageIndex <= 0 {
+		This.PageIndex = 1
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	for _, repoId := range This.RepoIds {
+		if !utils.IsContainsInt(permissionToken.RepoIds, repoId) {
+			return ico.Err(2007, "权限不足")
+		}
+	}
+	// 1.规则库
+	repoDataList, code, err := public.GetRepos(token, This.RepoIds)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	repos := []public.RepoInfo{}
+	for _, repo := range repoDataList.List {
+		if utils.IsContainsInt(permissionToken.RepoIds, repo.RepoId) {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].RepoId < repos[j].RepoId })
+	// 2.并发查询各规则库
+	hits := make([][]SearchHit, len(repos))
+	res := RuleSearchRes{List: []SearchHit{}, Repos: make([]SearchRepo, len(repos))}
+	sem := make(chan struct{}, repoParallel)
+	wg := sync.WaitGroup{}
+	for i, repo := range repos {
+		res.Repos[i] = SearchRepo{RepoId: repo.RepoId, Name: repo.Name}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, repoId int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ruleDataList, _, err := public.GetRepoRules(token, repoId)
+			if err != nil {
+				res.Repos[i].Error = err.Error()
+				return
+			}
+			hits[i] = This.Search(re, repoId, ruleDataList.List)
+			res.Repos[i].Count = len(hits[i])
+		}(i, repo.RepoId)
+	}
+	wg.Wait()
+	// 3.分页
+	all := []SearchHit{}
+	for _, repoHits := range hits {
+		all = append(all, repoHits...)
+	}
+	res.Total = len(all)
+	start := (This.PageIndex - 1) * This.PageSize
+	if start < len(all) {
+		end := start + This.PageSize
+		if end > len(all) {
+			end = len(all)
+		}
+		res.List = append(res.List, all[start:end]...)
+	}
+	return ico.Succ(res)
+}
+
+// Compile 子串按字面量转义，忽略大小写时加(?i)
+func (This RuleSearch) Compile() (*regexp.Regexp, error) {
+	expr := This.Keyword
+	if !This.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if This.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// Search 单个规则库中满足过滤条件且有字段命中的规则，按规则id、行号排序
+func (This RuleSearch) Search(re *regexp.Regexp, repoId int, rules []public.RuleInfo) []SearchHit {
+	fields := This.Fields
+	if len(fields) == 0 {
+		fields = []string{searchPattern, searchDesc, searchSample}
+	}
+	hits := []SearchHit{}
+	for _, rule := range rules {
+		if !This.Filter.Match(rule) {
+			continue
+		}
+		matches := []SearchMatch{}
+		attr := rule.Attribute()
+		for _, field := range fields {
+			switch field {
+			case searchPattern:
+				keys := []string{}
+				for key := range rule.Pattern {
+					if len(This.PatternKeys) == 0 || utils.IsContainsStr(This.PatternKeys, key) {
+						keys = append(keys, key)
+					}
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					if match, ok := highlight(re, searchPattern+"."+key, rule.Pattern[key]); ok {
+						matches = append(matches, match)
+					}
+				}
+			case searchDesc:
+				if match, ok := highlight(re, searchDesc, attr.Desc); ok {
+					matches = append(matches, match)
+				}
+			case searchSample:
+				if match, ok := highlight(re, searchSample, attr.Sample); ok {
+					matches = append(matches, match)
+				}
+			}
+		}
+		if len(matches) != 0 {
+			hits = append(hits, SearchHit{RuleListItem: NewRuleListItem(repoId, rule), Matches: matches})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].RuleId != hits[j].RuleId {
+			return hits[i].RuleId < hits[j].RuleId
+		}
+		return hits[i].LineNum < hits[j].LineNum
+	})
+	return hits
+}
+
+// highlight 标记命中部分，忽略空匹配；规则体、描述、样例均为用户输入，标记前逐段转义
+func highlight(re *regexp.Regexp, field, value string) (match SearchMatch, ok bool) {
+	match = SearchMatch{Field: field, Ranges: [][2]int{}}
+	b := strings.Builder{}
+	last := 0
+	for _, loc := range re.FindAllStringIndex(value, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		match.Ranges = append(match.Ranges, [2]int{loc[0], loc[1]})
+		b.WriteString(html.EscapeString(value[last:loc[0]]))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(value[loc[0]:loc[1]]))
+		b.WriteString(highlightEnd)
+		last = loc[1]
+	}
+	if len(match.Ranges) == 0 {
+		return match, false
+	}
+	b.WriteString(html.EscapeString(value[last:]))
+	match.Highlight = b.String()
+	return match, true
+}
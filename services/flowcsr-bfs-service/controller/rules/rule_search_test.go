@@ -0,0 +1,30 @@
+package rules
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		name      string
+		expr      string
+		value     string
+		ok        bool
+		ranges    [][2]int
+		highlight string
+	}{
+		{"未命中", "x", "abc", false, [][2]int{}, ""},
+		{"多处命中", "a", "abca", true, [][2]int{{0, 1}, {3, 4}}, "<em>a</em>bc<em>a</em>"},
+		{"忽略空匹配", "a*", "bab", true, [][2]int{{1, 2}}, "b<em>a</em>b"},
+		{"转义原文", "img", `<img src=x onerror="a">`, true, [][2]int{{1, 4}}, `&lt;<em>img</em> src=x onerror=&#34;a&#34;&gt;`},
+		{"转义命中部分", "<b>", "a<b>c", true, [][2]int{{1, 4}}, "a<em>&lt;b&gt;</em>c"},
+	}
+	for _, c := range cases {
+		match, ok := highlight(regexp.MustCompile(c.expr), "desc", c.value)
+		if ok != c.ok || !reflect.DeepEqual(match.Ranges, c.ranges) || match.Highlight != c.highlight {
+			t.Errorf("%s: got %v %+v", c.name, ok, match)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// 单次最多修改的规则数，每条规则按删除后重新新增修改，见ReplaceRules
+const maxStatusRules = 200
+
+// RuleStatus 批量启用、停用规则，RuleIds与Filter二选一，修改整库时需All为true
+type RuleStatus struct {
+	RepoId  int         `json:"repo_id"      binding:"required"`
+	Status  *int        `json:"status"       binding:"required"`
+	RuleIds []int       `json:"rule_ids"`
+	Filter  *RuleFilter `json:"filter"`
+	All     bool        `json:"all"` // 为true时filter可为空，修改规则库全部规则
+}
+
+type RuleStatusRes struct {
+	response.WriteRes
+	Changed   []int `json:"changed"`
+	Unchanged []int `json:"unchanged"` // 已是目标状态
+}
+
+func (This RuleStatus) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则状态修改")
+	if err := CheckStatus(*This.Status); err != nil {
+		return ico.Err(2099, err.Error())
+	}
+	if This.All && This.Filter == nil {
+		This.Filter = &RuleFilter{}
+	}
+	if (len(This.RuleIds) == 0) == (This.Filter == nil) {
+		return ico.Err(2099, "rule_ids与filter需且仅需指定一个")
+	}
+	if This.Filter != nil && This.Filter.IsEmpty() && !This.All {
+		return ico.Err(2099, "filter为空会修改规则库全部规则，需指定all为true")
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 1.规则查询，按条件筛选时查询整库
+	var ruleDataList public.RuleListRes
+	if This.Filter != nil {
+		ruleDataList, code, err = public.GetRepoRules(token, This.RepoId)
+	} else {
+		ruleDataList, code, err = public.GetRules(token, This.RepoId, This.RuleIds)
+	}
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	found := map[int]bool{}
+	for _, ruleData := range ruleDataList.List {
+		found[ruleData.RuleId] = true
+	}
+	for _, ruleId := range This.RuleIds {
+		if !found[ruleId] {
+			return ico.Err(2301, fmt.Sprintf("规则[%d]不存在", ruleId))
+		}
+	}
+	// 2.区分需修改的规则
+	res := RuleStatusRes{Changed: []int{}, Unchanged: []int{}}
+	updates := []RuleUpdateItem{}
+	for _, ruleData := range ruleDataList.List {
+		if This.Filter != nil && !This.Filter.Match(ruleData) {
+			continue
+		}
+		before := ruleData.Message()
+		if before.Attr.Status == *This.Status {
+			res.Unchanged = append(res.Unchanged, ruleData.RuleId)
+			continue
+		}
+		after := before
+		after.Attr.Status = *This.Status
+		updates = append(updates, RuleUpdateItem{LineNum: ruleData.LineNum, Before: before, After: after, Fields: []string{"attr"}})
+		res.Changed = append(res.Changed, ruleData.RuleId)
+	}
+	if len(updates) > maxStatusRules {
+		return ico.Err(2099, fmt.Sprintf("需修改%d条规则，单次最多修改%d条，请缩小范围", len(updates), maxStatusRules))
+	}
+	if len(updates) == 0 {
+		res.WriteRes = response.WriteRes{Message: "无修改", ChangeSet: *response.NewChangeSet("规则状态修改")}
+		return ico.Succ(res)
+	}
+	// 3.修改规则
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	audit.AddRefs(c, audit.KindRule, res.Changed...)
+	j := journal.Begin(token, scheduledOperation(c, "规则状态修改"))
+	j.Snapshot(This.RepoId, ruleDataList.List...)
+	lineNums, code, err := ReplaceRules(token, j, This.RepoId, updates)
+	if err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	for _, update := range updates {
+		j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: update.After.Id, LineNum: lineNums[update.After.Id], Fields: update.Fields})
+	}
+	message := j.ChangeSet.Summary()
+	logger.Info(message)
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	res.WriteRes = response.WriteRes{Message: "修改成功", ChangeSet: *j.ChangeSet}
+	return ico.Succ(res)
+}
@@ -0,0 +1,425 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/ruleid"
+	"bigrule/services/flowcsr-bfs-service/model/template"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 模板占位符，如{{keyword}}
+var placeholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// 行变量中的规则id，auto_id时忽略
+const varId = "id"
+
+// TemplateSave 新增或修改规则模板，Id为0时新增
+type TemplateSave struct {
+	Id     int           `json:"id"`
+	Name   string        `json:"name"      binding:"required"`
+	RepoId int           `json:"repo_id"   binding:"required"`
+	Desc   string        `json:"desc"`
+	Spec   template.Spec `json:"spec"      binding:"required"`
+}
+
+// TemplateList 查询规则模板，RepoId为0时查询有权限的全部规则库
+type TemplateList struct {
+	RepoId int `json:"repo_id"`
+}
+
+type TemplateDelete struct {
+	Id int `json:"id" binding:"required"`
+}
+
+// TemplateInfo 模板及其用到的变量
+type TemplateInfo struct {
+	template.Template
+	Spec      template.Spec `json:"spec"`
+	Variables []string      `json:"variables"`
+}
+
+// TemplateExpand 按变量行展开模板，每行生成一条规则
+type TemplateExpand struct {
+	TemplateId  int                 `json:"template_id"     binding:"required"`
+	TagValTblId int                 `json:"tagval_tbl_id"   binding:"required"`
+	Rows        []map[string]string `json:"rows"            binding:"required"`
+	AutoId      bool                `json:"auto_id"`
+	Regression  bool                `json:"regression"`
+}
+
+// TemplatePreview 展开并校验，不写入；auto_id时的规则id为预览值，提交时重新分配
+type TemplatePreview struct {
+	TemplateExpand
+}
+
+// TemplateSubmit 展开后按批量新增规则的流程写入
+type TemplateSubmit struct {
+	TemplateExpand
+}
+
+type TemplatePreviewRes struct {
+	RuleList   []Rule         `json:"rule_list"`
+	Tags       []Tag          `json:"tags"`
+	Errors     []RuleError    `json:"errors"`
+	Regression *RegressReport `json:"regression,omitempty"`
+}
+
+func (This TemplateSave) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则模板保存")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	if This.Id != 0 {
+		old, err := template.Get(This.Id)
+		if err != nil {
+			return ico.Err(2099, err.Error())
+		}
+		if !utils.IsContainsInt(permissionToken.RepoIds, old.RepoId) {
+			return ico.Err(2007, "权限不足")
+		}
+	}
+	// 1.校验模板
+	repo, code, err := public.GetRepo(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if errs := CheckSpec(This.Spec, repo); len(errs) != 0 {
+		res := ico.Err(2098, "模板校验失败")
+		res.Data = errs
+		return res
+	}
+	// 2.保存
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	t := template.Template{Id: This.Id, Name: This.Name, RepoId: This.RepoId, Desc: This.Desc, UserName: middleware.GetCaller(token).UserName}
+	if err := template.Save(&t, This.Spec); err != nil {
+		if errors.Is(err, template.ErrDuplicateName) {
+			return ico.Err(2099, err.Error())
+		}
+		logger.Error("规则模板保存失败 ", err.Error())
+		return ico.Err(2301, "模板保存失败", err.Error())
+	}
+	return ico.Succ(NewTemplateInfo(t, This.Spec))
+}
+
+func (This TemplateList) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则模板查询")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	repoIds := permissionToken.RepoIds
+	if This.RepoId != 0 {
+		if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+			return ico.Err(2007, "权限不足")
+		}
+		repoIds = []int{This.RepoId}
+	}
+	list := []TemplateInfo{}
+	if len(repoIds) == 0 {
+		return ico.Succ(list)
+	}
+	templates, err := template.List(repoIds)
+	if err != nil {
+		logger.Error("规则模板查询失败 ", err.Error())
+		return ico.Err(2301, "数据查询失败")
+	}
+	for _, t := range templates {
+		spec, err := t.Decode()
+		if err != nil {
+			logger.Error("规则模板解析失败 ", err.Error())
+			continue
+		}
+		list = append(list, NewTemplateInfo(t, spec))
+	}
+	return ico.Succ(list)
+}
+
+func (This TemplateDelete) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则模板删除")
+	t, err := template.Get(This.Id)
+	if err != nil {
+		return ico.Err(2099, err.Error())
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, t.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	audit.AddRefs(c, audit.KindRepo, t.RepoId)
+	if err := template.Delete(This.Id); err != nil {
+		logger.Error("规则模板删除失败 ", err.Error())
+		return ico.Err(2301, "模板删除失败")
+	}
+	return ico.Succ(nil)
+}
+
+func (This TemplatePreview) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则模板预览")
+	add, res := This.RuleAdd(token)
+	if res != nil {
+		return res
+	}
+	preview := TemplatePreviewRes{RuleList: add.RuleList, Tags: add.TagVal.Tag, Errors: []RuleError{}}
+	// 1.auto_id时与预留相同，按库中及预留中的最大id顺延作为预览id
+	if This.AutoId {
+		ruleDataList, code, err := public.GetRepoRules(token, add.RepoId)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		maxId, err := ruleid.MaxReserved(add.RepoId)
+		if err != nil {
+			logger.Error("规则id预留查询失败 ", err.Error())
+			return ico.Err(2301, err.Error())
+		}
+		for _, ruleData := range ruleDataList.List {
+			if ruleData.RuleId > maxId {
+				maxId = ruleData.RuleId
+			}
+		}
+		for i := range add.RuleList {
+			add.RuleList[i].Id = maxId + i + 1
+		}
+	}
+	// 2.与批量新增相同的校验
+	_, ruleErrors, code, err := add.Validate(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	preview.Errors = append(preview.Errors, ruleErrors...)
+	if This.Regression && len(ruleErrors) == 0 {
+		report, code, err := add.Regress(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		preview.Regression = &report
+	}
+	return ico.Succ(preview)
+}
+
+func (This TemplateSubmit) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("规则模板提交")
+	add, res := This.RuleAdd(token)
+	if res != nil {
+		return res
+	}
+	return add.Handle(c)
+}
+
+// RuleAdd 查询模板并展开为批量新增入参，展开有误时返回错误结果
+func (This TemplateExpand) RuleAdd(token string) (add RuleAdd, res *ico.Result) {
+	t, err := template.Get(This.TemplateId)
+	if err != nil {
+		return add, ico.Err(2099, err.Error())
+	}
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return add, ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, t.RepoId) {
+		return add, ico.Err(2007, "权限不足")
+	}
+	if !utils.IsContainsInt(permissionToken.TagValIds, This.TagValTblId) {
+		return add, ico.Err(2007, "权限不足")
+	}
+	spec, err := t.Decode()
+	if err != nil {
+		return add, ico.Err(2301, "模板解析失败", err.Error())
+	}
+	add, ruleErrors := This.Expand(t.RepoId, spec)
+	if len(ruleErrors) != 0 {
+		res = ico.Err(2098, "模板展开失败")
+		res.Data = ruleErrors
+	}
+	return
+}
+
+// Expand 逐行代入变量生成规则及需新增的标签，返回每行的错误
+func (This TemplateExpand) Expand(repoId int, spec template.Spec) (add RuleAdd, ruleErrors []RuleError) {
+	add = RuleAdd{RepoId: repoId, TagVal: &TagVal{TagValTblId: This.TagValTblId, Tag: []Tag{}}, AutoId: This.AutoId, Regression: This.Regression}
+	tagNames := map[int]string{}
+	for i, row := range This.Rows {
+		errs := []string{}
+		missing := map[string]bool{}
+		rule := Rule{Attr: spec.Attr, Pattern: map[string]string{}, Dimensions: []DimensionAddRule{}}
+		if !This.AutoId {
+			id, err := strconv.Atoi(row[varId])
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("变量%s[%s]不是整数", varId, row[varId]))
+			}
+			rule.Id = id
+		}
+		for key, value := range spec.Pattern {
+			rule.Pattern[key] = render(value, row, spec.Quote, missing)
+		}
+		rule.Attr.Sample = render(spec.Attr.Sample, row, false, missing)
+		rule.Attr.Desc = render(spec.Attr.Desc, row, false, missing)
+		for _, binding := range spec.Dimensions {
+			if binding.IdVar == "" {
+				rule.Dimensions = append(rule.Dimensions, DimensionAddRule{DimensionId: binding.DimensionId, ValueId: binding.ValueId})
+				continue
+			}
+			value, ok := row[binding.IdVar]
+			if !ok {
+				missing[binding.IdVar] = true
+				continue
+			}
+			tagId, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("变量%s[%s]不是整数", binding.IdVar, value))
+				continue
+			}
+			rule.Dimensions = append(rule.Dimensions, DimensionAddRule{DimensionId: binding.DimensionId, ValueId: tagId})
+			if binding.NameVar == "" {
+				continue
+			}
+			name, ok := row[binding.NameVar]
+			if !ok {
+				missing[binding.NameVar] = true
+				continue
+			}
+			if old, ok := tagNames[tagId]; ok {
+				if old != name {
+					errs = append(errs, fmt.Sprintf("标签[%d]名称与前面的行不一致", tagId))
+				}
+				continue
+			}
+			tagNames[tagId] = name
+			add.TagVal.Tag = append(add.TagVal.Tag, Tag{Id: tagId, Value: name})
+		}
+		for _, name := range sortedKeys(missing) {
+			errs = append(errs, fmt.Sprintf("缺少变量%s", name))
+		}
+		if len(errs) != 0 {
+			ruleErrors = append(ruleErrors, RuleError{Index: i, Id: rule.Id, Errors: errs})
+		}
+		add.RuleList = append(add.RuleList, rule)
+	}
+	return
+}
+
+// CheckSpec 校验模板规则体、维度绑定
+func CheckSpec(spec template.Spec, repo public.RepoInfo) (errs []string) {
+	if len(spec.Pattern) == 0 {
+		errs = append(errs, "规则体为空")
+	}
+	// 占位符代入任意值后规则体需为合法正则
+	for key, value := range spec.Pattern {
+		if _, err := regexp.Compile(placeholder.ReplaceAllString(value, "x")); err != nil {
+			errs = append(errs, fmt.Sprintf("规则体[%s]正则错误：%s", key, err.Error()))
+		}
+	}
+	dimIds := map[int]bool{}
+	for _, dim := range repo.DimensionMsg {
+		dimIds[dim.DimensionId] = true
+	}
+	for _, binding := range spec.Dimensions {
+		if !dimIds[binding.DimensionId] {
+			errs = append(errs, fmt.Sprintf("维度[%d]不属于该规则库", binding.DimensionId))
+		}
+		if (binding.ValueId == 0) == (binding.IdVar == "") {
+			errs = append(errs, fmt.Sprintf("维度[%d]需指定固定标签或标签id变量之一", binding.DimensionId))
+		}
+		if binding.NameVar != "" && binding.IdVar == "" {
+			errs = append(errs, fmt.Sprintf("维度[%d]指定标签名称变量时需指定标签id变量", binding.DimensionId))
+		}
+	}
+	return
+}
+
+func NewTemplateInfo(t template.Template, spec template.Spec) TemplateInfo {
+	return TemplateInfo{Template: t, Spec: spec, Variables: Variables(spec)}
+}
+
+// Variables 模板用到的变量名
+func Variables(spec template.Spec) []string {
+	names := map[string]bool{}
+	texts := []string{spec.Attr.Sample, spec.Attr.Desc}
+	for _, value := range spec.Pattern {
+		texts = append(texts, value)
+	}
+	for _, text := range texts {
+		for _, match := range placeholder.FindAllStringSubmatch(text, -1) {
+			names[match[1]] = true
+		}
+	}
+	for _, binding := range spec.Dimensions {
+		if binding.IdVar != "" {
+			names[binding.IdVar] = true
+		}
+		if binding.NameVar != "" {
+			names[binding.NameVar] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+// render 代入变量，quote为true时按字面量转义，缺少的变量记入missing
+func render(text string, row map[string]string, quote bool, missing map[string]bool) string {
+	return placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		name := placeholder.FindStringSubmatch(m)[1]
+		value, ok := row[name]
+		if !ok {
+			missing[name] = true
+			return m
+		}
+		if quote {
+			return regexp.QuoteMeta(value)
+		}
+		return value
+	})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
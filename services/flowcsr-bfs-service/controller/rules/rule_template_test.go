@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"bigrule/services/flowcsr-bfs-service/model/template"
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		row     map[string]string
+		quote   bool
+		want    string
+		missing []string
+	}{
+		{"代入", "{{host}}/{{ path }}", map[string]string{"host": "a.com", "path": "x"}, false, "a.com/x", []string{}},
+		{"转义", "^{{host}}$", map[string]string{"host": "a.com"}, true, `^a\.com$`, []string{}},
+		{"缺少变量", "{{host}}{{port}}", map[string]string{"host": "a"}, false, "a{{port}}", []string{"port"}},
+		{"无占位符", "abc", nil, false, "abc", []string{}},
+	}
+	for _, c := range cases {
+		missing := map[string]bool{}
+		got := render(c.text, c.row, c.quote, missing)
+		if got != c.want || !reflect.DeepEqual(sortedKeys(missing), c.missing) {
+			t.Errorf("%s: got %q, missing %v", c.name, got, sortedKeys(missing))
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	spec := template.Spec{
+		Pattern: map[string]string{"url": "{{host}}"},
+		Attr:    response.Attribute{Priority: 10, Status: 1, Desc: "{{host}}"},
+		Quote:   true,
+		Dimensions: []template.Binding{
+			{DimensionId: 1, ValueId: 100},
+			{DimensionId: 2, IdVar: "tag_id", NameVar: "tag_name"},
+		},
+	}
+	cases := []struct {
+		name   string
+		rows   []map[string]string
+		autoId bool
+		ids    []int
+		tags   []Tag
+		errors map[int][]string
+	}{
+		{"展开", []map[string]string{
+			{"id": "1", "host": "a.com", "tag_id": "7", "tag_name": "A"},
+			{"id": "2", "host": "b.com", "tag_id": "7", "tag_name": "A"},
+		}, false, []int{1, 2}, []Tag{{Id: 7, Value: "A"}}, map[int][]string{}},
+		{"auto_id忽略id变量", []map[string]string{{"host": "a.com", "tag_id": "7", "tag_name": "A"}}, true, []int{0}, []Tag{{Id: 7, Value: "A"}}, map[int][]string{}},
+		{"变量错误", []map[string]string{
+			{"id": "x", "host": "a.com", "tag_id": "7", "tag_name": "A"},
+			{"id": "2", "tag_id": "y"},
+			{"id": "3", "host": "c.com", "tag_id": "7", "tag_name": "B"},
+		}, false, []int{0, 2, 3}, []Tag{{Id: 7, Value: "A"}}, map[int][]string{
+			0: {"变量id[x]不是整数"},
+			1: {"变量tag_id[y]不是整数", "缺少变量host"},
+			2: {"标签[7]名称与前面的行不一致"},
+		}},
+	}
+	for _, c := range cases {
+		add, ruleErrors := TemplateExpand{TagValTblId: 5, Rows: c.rows, AutoId: c.autoId}.Expand(1, spec)
+		ids := []int{}
+		for _, rule := range add.RuleList {
+			ids = append(ids, rule.Id)
+		}
+		errors := map[int][]string{}
+		for _, ruleError := range ruleErrors {
+			errors[ruleError.Index] = ruleError.Errors
+		}
+		if !reflect.DeepEqual(ids, c.ids) || !reflect.DeepEqual(add.TagVal.Tag, c.tags) || !reflect.DeepEqual(errors, c.errors) {
+			t.Errorf("%s: got ids %v, tags %v, errors %v", c.name, ids, add.TagVal.Tag, errors)
+		}
+	}
+	add, _ := TemplateExpand{TagValTblId: 5, Rows: []map[string]string{{"id": "1", "host": "a.com", "tag_id": "7", "tag_name": "A"}}}.Expand(1, spec)
+	rule := add.RuleList[0]
+	if rule.Pattern["url"] != `a\.com` || rule.Attr.Desc != "a.com" ||
+		!reflect.DeepEqual(rule.Dimensions, []DimensionAddRule{{DimensionId: 1, ValueId: 100}, {DimensionId: 2, ValueId: 7}}) {
+		t.Errorf("unexpected rule %+v", rule)
+	}
+}
+
+func TestCheckSpec(t *testing.T) {
+	repo := public.RepoInfo{DimensionMsg: []public.DimensionInfo{{DimensionId: 1}}}
+	cases := []struct {
+		name string
+		spec template.Spec
+		want []string
+	}{
+		{"合法", template.Spec{Pattern: map[string]string{"url": "^{{host}}$"}, Dimensions: []template.Binding{{DimensionId: 1, IdVar: "tag_id"}}}, nil},
+		{"规则体为空", template.Spec{}, []string{"规则体为空"}},
+		{"正则错误", template.Spec{Pattern: map[string]string{"url": "({{host}}"}}, []string{"规则体[url]正则错误：error parsing regexp: missing closing ): `(x`"}},
+		{"维度不属于规则库", template.Spec{Pattern: map[string]string{"url": "a"}, Dimensions: []template.Binding{{DimensionId: 2, ValueId: 1}}},
+			[]string{"维度[2]不属于该规则库"}},
+		{"固定标签与变量同时指定", template.Spec{Pattern: map[string]string{"url": "a"}, Dimensions: []template.Binding{{DimensionId: 1, ValueId: 1, IdVar: "tag_id"}}},
+			[]string{"维度[1]需指定固定标签或标签id变量之一"}},
+		{"只指定名称变量", template.Spec{Pattern: map[string]string{"url": "a"}, Dimensions: []template.Binding{{DimensionId: 1, NameVar: "tag_name"}}},
+			[]string{"维度[1]需指定固定标签或标签id变量之一", "维度[1]指定标签名称变量时需指定标签id变量"}},
+	}
+	for _, c := range cases {
+		if got := CheckSpec(c.spec, repo); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package rules
+
+import (
+	"bigrule/common/ico"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// RuleUpdate 批量修改规则，修改后的规则移至库末尾，见ReplaceRules
+type RuleUpdate struct {
+	RepoId   int          `json:"repo_id"      binding:"required"`
+	RuleList []RuleChange `json:"rule_list"    binding:"required"`
+}
+
+// RuleChange 未传的字段保持不变
+type RuleChange struct {
+	Id         int                 `json:"id"          binding:"required"`
+	Pattern    map[string]string   `json:"pattern"`    // 按key合并，值为空删除该key
+	Attr       *AttributeChange    `json:"attr"`       // 按字段修改
+	Dimensions *[]DimensionAddRule `json:"dimensions"` // 整体替换
+}
+
+type AttributeChange struct {
+	Priority *int    `json:"priority"`
+	Status   *int    `json:"status"`
+	Sample   *string `json:"sample"`
+	Desc     *string `json:"desc"`
+}
+
+// 单条规则修改前后内容，LineNum为修改前的行号
+type RuleUpdateItem struct {
+	LineNum int
+	Before  public.RuleMessage
+	After   public.RuleMessage
+	Fields  []string
+}
+
+func (This RuleUpdate) DoHandle(c *gin.Context) *ico.Result {
+	if err := c.ShouldBindJSON(&This); err != nil {
+		return ico.Err(2099, "", err.Error())
+	}
+	token := c.GetHeader("X-Access-Token")
+	token = strings.Split(token, ";")[0]
+	logger.Info("批量规则修改")
+	// 0.权限判断
+	permissionToken, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	if !utils.IsContainsInt(permissionToken.RepoIds, This.RepoId) {
+		return ico.Err(2007, "权限不足")
+	}
+	// 1.规则查询，获取行号及修改前内容
+	ruleIds := []int{}
+	for _, rule := range This.RuleList {
+		if utils.IsContainsInt(ruleIds, rule.Id) {
+			return ico.Err(2099, fmt.Sprintf("规则[%d]重复修改", rule.Id))
+		}
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	ruleDataList, code, err := public.GetRules(token, This.RepoId, ruleIds)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	ruleDataMap := map[int]public.RuleInfo{}
+	for _, ruleData := range ruleDataList.List {
+		if _, ok := ruleDataMap[ruleData.RuleId]; ok {
+			return ico.Err(2301, "有多条重复id规则")
+		}
+		ruleDataMap[ruleData.RuleId] = ruleData
+	}
+	// 2.合并修改
+	updates := []RuleUpdateItem{}
+	for _, rule := range This.RuleList {
+		ruleData, ok := ruleDataMap[rule.Id]
+		if !ok {
+			return ico.Err(2301, fmt.Sprintf("规则[%d]不存在", rule.Id))
+		}
+		before := ruleData.Message()
+		after, fields := rule.Apply(before)
+		if len(fields) == 0 {
+			continue
+		}
+		updates = append(updates, RuleUpdateItem{LineNum: ruleData.LineNum, Before: before, After: after, Fields: fields})
+	}
+	if len(updates) == 0 {
+		return ico.Succ(response.WriteRes{Message: "无修改", ChangeSet: *response.NewChangeSet("批量规则修改")})
+	}
+	// 2.1 修改了的字段按规则库校验，修改了维度的规则关联的标签需已存在
+	repo, code, err := public.GetRepo(token, This.RepoId)
+	if err != nil {
+		return ico.Err(code, err.Error())
+	}
+	validator := RuleValidator{Repo: repo}
+	ruleErrors := validator.ValidateUpdates(updates)
+	changed := []public.RuleMessage{}
+	updateIndex := map[int]int{}
+	for i, update := range updates {
+		if utils.IsContainsStr(update.Fields, "dimensions") {
+			changed = append(changed, update.After)
+			updateIndex[update.After.Id] = i
+		}
+	}
+	if len(changed) != 0 {
+		add := RuleAdd{RepoId: This.RepoId, RuleList: NewRules(changed)}
+		_, tagErrors, code, err := add.CheckTags(token)
+		if err != nil {
+			return ico.Err(code, err.Error())
+		}
+		for _, tagError := range tagErrors {
+			for _, message := range tagError.Errors {
+				ruleErrors = addRuleError(ruleErrors, updateIndex[tagError.Id], tagError.Id, message)
+			}
+		}
+	}
+	if len(ruleErrors) != 0 {
+		// 下标换算为入参中的下标
+		for i := range ruleErrors {
+			for index, rule := range This.RuleList {
+				if rule.Id == ruleErrors[i].Id {
+					ruleErrors[i].Index = index
+				}
+			}
+		}
+		res := ico.Err(2098, "规则校验失败")
+		res.Data = ruleErrors
+		return res
+	}
+	// 3.修改规则
+	audit.AddRefs(c, audit.KindRepo, This.RepoId)
+	audit.AddRefs(c, audit.KindRule, ruleIds...)
+	j := journal.Begin(token, "批量规则修改")
+	j.Snapshot(This.RepoId, ruleDataList.List...)
+	lineNums, code, err := ReplaceRules(token, j, This.RepoId, updates)
+	if err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	for _, update := range updates {
+		j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: This.RepoId, Id: update.After.Id, LineNum: lineNums[update.After.Id], Fields: update.Fields})
+	}
+	message := j.ChangeSet.Summary()
+	logger.Info(message)
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(response.WriteRes{Message: "修改成功", ChangeSet: *j.ChangeSet})
+}
+
+// Apply 将修改合并到规则，返回修改后的规则及实际修改的字段
+func (rule RuleChange) Apply(before public.RuleMessage) (after public.RuleMessage, fields []string) {
+	after = before
+	if len(rule.Pattern) != 0 {
+		after.Pattern = map[string]string{}
+		for k, v := range before.Pattern {
+			after.Pattern[k] = v
+		}
+		for k, v := range rule.Pattern {
+			if v == "" {
+				delete(after.Pattern, k)
+				continue
+			}
+			after.Pattern[k] = v
+		}
+		if !reflect.DeepEqual(before.Pattern, after.Pattern) {
+			fields = append(fields, "pattern")
+		}
+	}
+	if rule.Attr != nil {
+		if rule.Attr.Priority != nil {
+			after.Attr.Priority = *rule.Attr.Priority
+		}
+		if rule.Attr.Status != nil {
+			after.Attr.Status = *rule.Attr.Status
+		}
+		if rule.Attr.Sample != nil {
+			after.Attr.Sample = *rule.Attr.Sample
+		}
+		if rule.Attr.Desc != nil {
+			after.Attr.Desc = *rule.Attr.Desc
+		}
+		if after.Attr != before.Attr {
+			fields = append(fields, "attr")
+		}
+	}
+	if rule.Dimensions != nil {
+		after.Dimensions = []public.RuleDimensionAdd{}
+		for _, dim := range *rule.Dimensions {
+			after.Dimensions = append(after.Dimensions, public.RuleDimensionAdd{DimensionId: dim.DimensionId, ValueId: dim.ValueId})
+		}
+		if !reflect.DeepEqual(before.Dimensions, after.Dimensions) {
+			fields = append(fields, "dimensions")
+		}
+	}
+	return
+}
+
+// ReplaceRules 上游没有规则修改接口，修改按删除原行后重新新增实现：先按行号倒序删除updates及removes的原行，
+// 再在库末尾新增updates修改后的规则，并重新关联原行的解析规则。修改后的规则不再保持原行号，
+// 匹配顺序随之改变，新行号由返回值给出
+func ReplaceRules(token string, j *journal.Journal, repoId int, updates []RuleUpdateItem, removes ...RuleUpdateItem) (lineNums map[int]int, code int, err error) {
+	lineNums = map[int]int{}
+	lines := append(append([]RuleUpdateItem{}, updates...), removes...)
+	// 1.原行关联的解析规则
+	parsers, parserRepoId, code, err := lineParsers(token, repoId, lines)
+	if err != nil {
+		return
+	}
+	// 2.删除原行，补偿为重新新增并关联解析规则
+	sort.SliceStable(lines, func(a, b int) bool { return lines[a].LineNum > lines[b].LineNum })
+	for _, line := range lines {
+		forward := journal.Call{Path: "/v1/rule-repo/rule/delete", Params: map[string]interface{}{"repo_id": repoId, "rule_id": line.Before.Id, "line_num": line.LineNum}}
+		compensate := []journal.Call{{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": repoId, "messages": []public.RuleMessage{line.Before}}}}
+		if parserId := parsers[lineOf(line.Before.Id, line.LineNum)]; parserId != 0 {
+			compensate = append(compensate, parserLink(repoId, line.Before.Id, parserRepoId, parserId))
+		}
+		step := j.Plan("删除原规则", forward, compensate...)
+		if code, err = journal.Exec(token, forward); err != nil {
+			return
+		}
+		j.Done(step)
+	}
+	if len(updates) == 0 {
+		return
+	}
+	// 3.库末尾新增修改后的规则，按删除后的行数确定新行号，执行前即可登记补偿
+	total, code, err := public.CountRules(token, repoId)
+	if err != nil {
+		return
+	}
+	messages := []public.RuleMessage{}
+	ruleIds := []int{}
+	compensate := []journal.Call{}
+	for i, update := range updates {
+		messages = append(messages, update.After)
+		ruleIds = append(ruleIds, update.After.Id)
+		compensate = append([]journal.Call{{Path: "/v1/rule-repo/rule/delete",
+			Params: map[string]interface{}{"repo_id": repoId, "rule_id": update.After.Id, "line_num": total + i + 1}}}, compensate...)
+	}
+	forward := journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": repoId, "messages": messages}}
+	step := j.Plan("新增修改后的规则", forward, compensate...)
+	if code, err = journal.Exec(token, forward); err != nil {
+		return
+	}
+	j.Done(step)
+	ruleDataList, code, err := public.GetRules(token, repoId, ruleIds)
+	if err != nil {
+		return
+	}
+	added := 0
+	for _, ruleData := range ruleDataList.List {
+		i := ruleData.LineNum - total - 1
+		if i < 0 || i >= len(updates) {
+			continue
+		}
+		if updates[i].After.Id != ruleData.RuleId {
+			return lineNums, 2301, fmt.Errorf("规则[%d]新增后的行号与预期不一致", updates[i].After.Id)
+		}
+		lineNums[ruleData.RuleId] = ruleData.LineNum
+		added++
+	}
+	if added != len(updates) {
+		return lineNums, 2301, errors.New("修改后的规则新增后行号与预期不一致")
+	}
+	// 4.重新关联解析规则
+	for _, update := range updates {
+		parserId := parsers[lineOf(update.Before.Id, update.LineNum)]
+		if parserId == 0 {
+			continue
+		}
+		forward := parserLink(repoId, update.After.Id, parserRepoId, parserId)
+		step := j.Plan("重新关联解析规则", forward, parserLink(repoId, update.After.Id, parserRepoId, 0))
+		if code, err = journal.Exec(token, forward); err != nil {
+			return
+		}
+		j.Done(step)
+	}
+	return
+}
+
+// lineParsers 原行关联的解析规则id，同时确认原行仍存在；有关联时返回规则库对应的解析规则库id
+func lineParsers(token string, repoId int, lines []RuleUpdateItem) (parsers map[ruleLine]int, parserRepoId int, code int, err error) {
+	parsers = map[ruleLine]int{}
+	ruleIds := []int{}
+	for _, line := range lines {
+		if !utils.IsContainsInt(ruleIds, line.Before.Id) {
+			ruleIds = append(ruleIds, line.Before.Id)
+		}
+	}
+	ruleDataList, code, err := public.GetRules(token, repoId, ruleIds)
+	if err != nil {
+		return
+	}
+	found := map[ruleLine]bool{}
+	for _, ruleData := range ruleDataList.List {
+		found[lineOf(ruleData.RuleId, ruleData.LineNum)] = true
+		if ruleData.ParserMessage.ParserId != 0 {
+			parsers[lineOf(ruleData.RuleId, ruleData.LineNum)] = ruleData.ParserMessage.ParserId
+		}
+	}
+	for _, line := range lines {
+		if !found[lineOf(line.Before.Id, line.LineNum)] {
+			return parsers, 0, 2301, fmt.Errorf("规则[%d]第%d行不存在", line.Before.Id, line.LineNum)
+		}
+	}
+	if len(parsers) == 0 {
+		return
+	}
+	repo, code, err := public.GetRepo(token, repoId)
+	if err != nil {
+		return
+	}
+	return parsers, repo.ParserMsg.Id, 0, nil
+}
+
+// parserLink 规则关联解析规则，parserId为0时解除关联
+func parserLink(repoId, ruleId, parserRepoId, parserId int) journal.Call {
+	params := map[string]interface{}{"repo_id": repoId, "rule_id": ruleId, "parser_repo_id": parserRepoId}
+	if parserId != 0 {
+		params["parser_id"] = parserId
+	}
+	return journal.Call{Path: "/v1/rule-repo/parser/link-unlink", Params: params}
+}
@@ -0,0 +1,165 @@
+package rules
+
+import (
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/config"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// RuleError 单条规则的校验错误，Index为该规则在入参中的下标
+type RuleError struct {
+	Index  int      `json:"index"`
+	Id     int      `json:"id"`
+	Errors []string `json:"errors"`
+}
+
+// RuleValidator 规则写入前校验
+type RuleValidator struct {
+	Repo       public.RepoInfo
+	Existing   []int // 库中已有的规则id，需避免冲突
+	Reserved   []int // 被其他调用方预留的规则id
+	ruleErrors []RuleError
+}
+
+// Validate 校验规则体、属性、批内id重复、与库中id及他人预留id冲突、维度归属，返回每条规则的错误
+func (v *RuleValidator) Validate(rules []public.RuleMessage) []RuleError {
+	existing := map[int]bool{}
+	for _, id := range v.Existing {
+		existing[id] = true
+	}
+	v.ruleErrors = []RuleError{}
+	seen := map[int]int{}
+	for i, rule := range rules {
+		// 1.id
+		if rule.Id <= 0 {
+			v.add(i, rule.Id, "id为空")
+		}
+		if first, ok := seen[rule.Id]; ok {
+			v.add(i, rule.Id, fmt.Sprintf("id与第%d条规则重复", first+1))
+		} else {
+			seen[rule.Id] = i
+		}
+		if existing[rule.Id] {
+			v.add(i, rule.Id, "id在规则库中已存在")
+		}
+		if utils.IsContainsInt(v.Reserved, rule.Id) {
+			v.add(i, rule.Id, "id已被其他用户预留")
+		}
+		// 2.规则体
+		v.pattern(i, rule)
+		// 3.属性
+		v.attr(i, rule)
+		// 4.维度
+		v.dimensions(i, rule)
+	}
+	return v.ruleErrors
+}
+
+// ValidateUpdates 只校验修改了的字段，用于修改规则，Index为updates中的下标
+func (v *RuleValidator) ValidateUpdates(updates []RuleUpdateItem) []RuleError {
+	v.ruleErrors = []RuleError{}
+	for i, update := range updates {
+		if utils.IsContainsStr(update.Fields, "pattern") {
+			v.pattern(i, update.After)
+		}
+		if utils.IsContainsStr(update.Fields, "attr") {
+			v.attr(i, update.After)
+		}
+		if utils.IsContainsStr(update.Fields, "dimensions") {
+			v.dimensions(i, update.After)
+		}
+	}
+	return v.ruleErrors
+}
+
+// attr 优先级、状态按配置校验
+func (v *RuleValidator) attr(index int, rule public.RuleMessage) {
+	if err := CheckPriority(rule.Attr.Priority); err != nil {
+		v.add(index, rule.Id, err.Error())
+	}
+	if err := CheckStatus(rule.Attr.Status); err != nil {
+		v.add(index, rule.Id, err.Error())
+	}
+}
+
+// dimensions 维度需属于规则库
+func (v *RuleValidator) dimensions(index int, rule public.RuleMessage) {
+	for _, dim := range rule.Dimensions {
+		owned := false
+		for _, repoDim := range v.Repo.DimensionMsg {
+			if repoDim.DimensionId == dim.DimensionId {
+				owned = true
+			}
+		}
+		if !owned {
+			v.add(index, rule.Id, fmt.Sprintf("维度[%d]不属于规则库[%d]", dim.DimensionId, v.Repo.RepoId))
+		}
+	}
+}
+
+// pattern 规则体需为合法正则，key需为规则库属性；规则库未配置属性时不校验key
+func (v *RuleValidator) pattern(index int, rule public.RuleMessage) {
+	if len(rule.Pattern) == 0 {
+		v.add(index, rule.Id, "规则体为空")
+	}
+	keys := []string{}
+	for key := range rule.Pattern {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := regexp.Compile(rule.Pattern[key]); err != nil {
+			v.add(index, rule.Id, fmt.Sprintf("规则体[%s]正则错误：%s", key, err.Error()))
+		}
+	}
+	if len(v.Repo.AttrMsg) == 0 {
+		return
+	}
+	attrs := map[string]bool{}
+	for _, attr := range v.Repo.AttrMsg {
+		attrs[attr.Name] = true
+	}
+	for _, key := range keys {
+		if !attrs[key] {
+			v.add(index, rule.Id, fmt.Sprintf("规则体[%s]不是规则库[%d]的属性", key, v.Repo.RepoId))
+		}
+	}
+}
+
+func (v *RuleValidator) add(index, id int, message string) {
+	v.ruleErrors = addRuleError(v.ruleErrors, index, id, message)
+}
+
+// addRuleError 同一条规则的错误合并
+func addRuleError(ruleErrors []RuleError, index, id int, message string) []RuleError {
+	for i, ruleError := range ruleErrors {
+		if ruleError.Index == index {
+			ruleErrors[i].Errors = append(ruleErrors[i].Errors, message)
+			return ruleErrors
+		}
+	}
+	return append(ruleErrors, RuleError{Index: index, Id: id, Errors: []string{message}})
+}
+
+// CheckPriority 按配置的范围校验优先级
+func CheckPriority(priority int) error {
+	limit := config.RuleConfig
+	if priority < limit.MinPriority {
+		return fmt.Errorf("优先级不能小于%d", limit.MinPriority)
+	}
+	if priority > limit.MaxPriority {
+		return fmt.Errorf("优先级不能大于%d", limit.MaxPriority)
+	}
+	return nil
+}
+
+// CheckStatus 按配置的取值校验状态
+func CheckStatus(status int) error {
+	if !utils.IsContainsInt(config.RuleConfig.Status, status) {
+		return fmt.Errorf("状态需为%v之一", config.RuleConfig.Status)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package rules
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"reflect"
+	"testing"
+)
+
+func TestRuleValidator(t *testing.T) {
+	config.RuleConfig = &config.Rule{MinPriority: 0, MaxPriority: 100, Status: []int{0, 1}}
+	defer func() { config.RuleConfig = config.DefaultRule() }()
+	repo := public.RepoInfo{
+		RepoId:       1,
+		DimensionMsg: []public.DimensionInfo{{DimensionId: 10}},
+		AttrMsg:      []public.AttrInfo{{Name: "url"}, {Name: "host"}},
+	}
+	valid := public.RuleMessage{Id: 1, Pattern: map[string]string{"url": `a\.com`}, Attr: response.Attribute{Priority: 10, Status: 1}}
+	with := func(f func(m *public.RuleMessage)) public.RuleMessage {
+		m := valid
+		m.Pattern = map[string]string{"url": `a\.com`}
+		f(&m)
+		return m
+	}
+	cases := []struct {
+		name     string
+		existing []int
+		rules    []public.RuleMessage
+		want     map[int][]string // 下标 -> 错误
+	}{
+		{"合法", nil, []public.RuleMessage{valid}, map[int][]string{}},
+		{"id为空", nil, []public.RuleMessage{with(func(m *public.RuleMessage) { m.Id = 0 })}, map[int][]string{0: {"id为空"}}},
+		{"批内重复", nil, []public.RuleMessage{valid, valid}, map[int][]string{1: {"id与第1条规则重复"}}},
+		{"库中已存在", []int{1}, []public.RuleMessage{valid}, map[int][]string{0: {"id在规则库中已存在"}}},
+		{"规则体为空", nil, []public.RuleMessage{with(func(m *public.RuleMessage) { m.Pattern = nil })}, map[int][]string{0: {"规则体为空"}}},
+		{"未知属性", nil, []public.RuleMessage{with(func(m *public.RuleMessage) { m.Pattern["path"] = "x" })},
+			map[int][]string{0: {"规则体[path]不是规则库[1]的属性"}}},
+		{"属性越界", nil, []public.RuleMessage{with(func(m *public.RuleMessage) { m.Attr.Priority = 101; m.Attr.Status = 2 })},
+			map[int][]string{0: {"优先级不能大于100", "状态需为[0 1]之一"}}},
+		{"维度不属于规则库", nil, []public.RuleMessage{with(func(m *public.RuleMessage) {
+			m.Dimensions = []public.RuleDimensionAdd{{DimensionId: 11, ValueId: 1}}
+		})}, map[int][]string{0: {"维度[11]不属于规则库[1]"}}},
+	}
+	for _, c := range cases {
+		validator := RuleValidator{Repo: repo, Existing: c.existing}
+		got := map[int][]string{}
+		for _, ruleError := range validator.Validate(c.rules) {
+			got[ruleError.Index] = ruleError.Errors
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	// 他人预留的id
+	validator := RuleValidator{Repo: repo, Reserved: []int{1}}
+	if ruleErrors := validator.Validate([]public.RuleMessage{valid}); len(ruleErrors) != 1 || ruleErrors[0].Errors[0] != "id已被其他用户预留" {
+		t.Fatalf("expected reserved id error, got %v", ruleErrors)
+	}
+
+	// 修改规则只校验修改了的字段
+	validator = RuleValidator{Repo: repo}
+	bad := with(func(m *public.RuleMessage) {
+		m.Pattern["url"] = "("
+		m.Attr.Priority = 101
+		m.Dimensions = []public.RuleDimensionAdd{{DimensionId: 11, ValueId: 1}}
+	})
+	updates := []RuleUpdateItem{
+		{Before: valid, After: bad, Fields: []string{"pattern"}},
+		{Before: valid, After: bad, Fields: []string{"attr"}},
+		{Before: valid, After: bad, Fields: []string{"dimensions"}},
+		{Before: valid, After: bad},
+	}
+	got := map[int][]string{}
+	for _, ruleError := range validator.ValidateUpdates(updates) {
+		got[ruleError.Index] = ruleError.Errors
+	}
+	want := map[int][]string{
+		0: {"规则体[url]正则错误：error parsing regexp: missing closing ): `(`"},
+		1: {"优先级不能大于100"},
+		2: {"维度[11]不属于规则库[1]"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// 未配置时按默认范围校验
+	config.RuleConfig = config.DefaultRule()
+	validator = RuleValidator{Repo: repo}
+	ruleErrors := validator.Validate([]public.RuleMessage{with(func(m *public.RuleMessage) { m.Attr.Priority = -1; m.Attr.Status = 5 })})
+	wantErrors := []RuleError{{Index: 0, Id: 1, Errors: []string{"优先级不能小于0", "状态需为[0 1]之一"}}}
+	if !reflect.DeepEqual(ruleErrors, wantErrors) {
+		t.Fatalf("expected %v with default config, got %v", wantErrors, ruleErrors)
+	}
+}
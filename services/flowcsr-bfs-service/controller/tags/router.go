@@ -11,7 +11,7 @@ import (
 type TagRouter struct{}
 
 func (sr TagRouter) Router(router *gin.Engine) {
-	r := router.Group(fmt.Sprintf("/%s/tags", global.Version)).Use(middleware.AuthToken())
+	r := router.Group(fmt.Sprintf("/%s/tags", global.Version)).Use(middleware.AuthToken(), middleware.Audit())
 	{
 		r.POST("/query", ico.Handler(TagQuery{}))
 		r.POST("/delete", ico.Handler(TagDelete{}))
@@ -5,6 +5,8 @@ import (
 	"bigrule/common/logger"
 	"bigrule/pkg/utils"
 	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bigrule/services/flowcsr-bfs-service/model/journal"
 	"bigrule/services/flowcsr-bfs-service/model/public"
 	"bigrule/services/flowcsr-bfs-service/model/response"
 	"encoding/json"
@@ -18,6 +20,7 @@ type TagDelete struct {
 	TagValTblId int    `json:"tagval_tbl_id"     binding:"required"`
 	TagId       int    `json:"tag_id"            binding:"required"`
 	RuleList    []Rule `json:"rule_list"`
+	DryRun      bool   `json:"dry_run"` // 仅返回删除预览
 }
 
 type Rule struct {
@@ -61,79 +64,197 @@ func (This TagDelete) DoHandle(c *gin.Context) *ico.Result {
 	if err != nil {
 		return ico.Err(code, err.Error())
 	}
-	// 2.通过规则库、维度、标签获取规则信息
+	// 2.通过规则库、维度、标签获取需解绑的规则
+	unlinkRules := []UnlinkRule{}
 	for _, dimData := range dimDataList.List {
 		ruleData, code, err := This.GetRuleData(token, dimData.RepoId, dimData.DimensionId)
 		if err != nil {
 			return ico.Err(code, err.Error())
 		}
-		// 3.解绑标签
 		for _, rule := range ruleData.List {
-			code, err = This.DeleteRuleTag(token, dimData.RepoId, rule.RuleId, dimData.DimensionId)
-			if err != nil {
-				return ico.Err(code, err.Error())
-			}
+			unlinkRules = append(unlinkRules, UnlinkRule{RepoId: dimData.RepoId, RuleId: rule.RuleId, DimensionId: dimData.DimensionId})
 		}
 	}
-	// 4.删除规则
-	message := fmt.Sprint("标签删除： ")
-	messageRule := " 删除规则：["
-	messageParser := " 删除解析规则：["
+	// 3.查询待删除的识别规则、解析规则，只能有一条
+	deleteRules := []public.RuleInfo{}
+	deleteParsers := []public.ParserInfo{}
 	for _, rule := range This.RuleList {
-		// 4.1 删除识别规则，只能有一条
-		ruleData, code, err := This.QueryRuleData(token, rule.RepoId, rule.RuleId)
+		ruleData, code, err := public.GetRules(token, rule.RepoId, []int{rule.RuleId})
 		if err != nil {
-			public.Cancel(token)
 			return ico.Err(code, err.Error())
 		}
 		if len(ruleData.List) != 1 {
 			logger.Info(ruleData.List)
-			public.Cancel(token)
 			return ico.Err(2301, "该规则有多条重复id")
 		}
-		if code, err = This.DeleteRule(token, rule.RepoId, rule.RuleId, ruleData.List[0].LineNum); err != nil {
-			public.Cancel(token)
-			return ico.Err(code, err.Error())
-		}
-		messageRule += fmt.Sprint(rule.RuleId)
-		// 4.2 删除解析规则，只能有一条
+		deleteRules = append(deleteRules, ruleData.List[0])
 		for _, parser := range rule.ParserList {
-			parserData, code, err := This.GetParserData(token, parser.ParserId, parser.RepoParserId)
+			parserData, code, err := public.GetParsers(token, parser.RepoParserId, parser.ParserId)
 			if err != nil {
-				public.Cancel(token)
 				return ico.Err(code, err.Error())
 			}
-			if len(parserData.List) != 1 {
-				public.Cancel(token)
+			if len(parserData) != 1 {
 				return ico.Err(2301, "该解析规则有多条重复id")
 			}
-			if code, err = This.DeleteParser(token, parser.ParserId, parser.RepoParserId, parserData.List[0].LineNum); err != nil {
-				public.Cancel(token)
-				return ico.Err(code, err.Error())
-			}
-			messageParser += fmt.Sprint(parser.ParserId)
+			parserData[0].ParserRepoId = parser.RepoParserId
+			deleteParsers = append(deleteParsers, parserData[0])
 		}
 	}
-	message += messageRule + "]"
-	message += messageParser + "]"
-	// 5.删除标签
+	// 4.查询待删除的标签
 	tagRes, code, err := This.GetTagData(token)
 	if err != nil {
-		public.Cancel(token)
 		return ico.Err(code, err.Error())
 	}
 	if len(tagRes.List) > 1 {
-		public.Cancel(token)
 		return ico.Err(2301, "有多条重复id标签")
 	}
-	if code, err := This.DeleteTag(token, tagRes.List[0].LineNum); err != nil {
-		public.Cancel(token)
+	if len(tagRes.List) == 0 {
+		return ico.Err(2099, fmt.Sprintf("标签[%d]不存在", This.TagId))
+	}
+	if This.DryRun {
+		return ico.Succ(This.Plan(unlinkRules, deleteRules, deleteParsers, tagRes.List[0]))
+	}
+	audit.AddRefs(c, audit.KindTagTable, This.TagValTblId)
+	audit.AddRefs(c, audit.KindTag, This.TagId)
+	for _, unlink := range unlinkRules {
+		audit.AddRefs(c, audit.KindRepo, unlink.RepoId)
+		audit.AddRefs(c, audit.KindRule, unlink.RuleId)
+	}
+	for _, rule := range This.RuleList {
+		audit.AddRefs(c, audit.KindRepo, rule.RepoId)
+		audit.AddRefs(c, audit.KindRule, rule.RuleId)
+	}
+	for _, parser := range deleteParsers {
+		audit.AddRefs(c, audit.KindParser, parser.ParserId)
+	}
+	// 5.解绑标签
+	j := journal.Begin(token, "标签删除")
+	for i, rule := range This.RuleList {
+		j.Snapshot(rule.RepoId, deleteRules[i])
+	}
+	unlinkIds := map[int][]int{}
+	for _, unlink := range unlinkRules {
+		unlinkIds[unlink.RepoId] = append(unlinkIds[unlink.RepoId], unlink.RuleId)
+	}
+	for repoId, ruleIds := range unlinkIds {
+		if ruleData, _, err := public.GetRules(token, repoId, ruleIds); err == nil {
+			j.Snapshot(repoId, ruleData.List...)
+		}
+	}
+	for _, unlink := range unlinkRules {
+		step := j.Plan("解绑标签",
+			journal.Call{Path: "/v1/rule-repo/dimension/link-unlink", Params: unlink.Params()},
+			journal.Call{Path: "/v1/rule-repo/dimension/link-unlink", Params: unlink.LinkParams(This.TagId)},
+		)
+		if code, err = This.DeleteRuleTag(token, unlink.RepoId, unlink.RuleId, unlink.DimensionId); err != nil {
+			j.Abort()
+			return ico.Err(code, err.Error())
+		}
+		j.ChangeSet.Update(response.ChangeItem{Type: response.ChangeRule, RepoId: unlink.RepoId, Id: unlink.RuleId, Fields: []string{"dimensions"}})
+		j.Done(step)
+	}
+	// 6.删除规则
+	for i, rule := range This.RuleList {
+		ruleData := deleteRules[i]
+		// 已解绑的维度由解绑步骤补偿，恢复规则时不再重复绑定
+		ruleMessage := ruleData.Message()
+		ruleMessage.Dimensions = []public.RuleDimensionAdd{}
+		for _, dim := range ruleData.Dimensions {
+			if dim.TagId == This.TagId && isUnlinked(unlinkRules, rule.RepoId, rule.RuleId, dim.DimensionId) {
+				continue
+			}
+			ruleMessage.Dimensions = append(ruleMessage.Dimensions, public.RuleDimensionAdd{DimensionId: dim.DimensionId, ValueId: dim.TagId})
+		}
+		step := j.Plan("删除规则",
+			journal.Call{Path: "/v1/rule-repo/rule/delete", Params: map[string]interface{}{"repo_id": rule.RepoId, "rule_id": rule.RuleId, "line_num": ruleData.LineNum}},
+			journal.Call{Path: "/v1/rule-repo/rule/batchadd", Params: map[string]interface{}{"repo_id": rule.RepoId, "messages": []public.RuleMessage{ruleMessage}}},
+		)
+		if code, err = This.DeleteRule(token, rule.RepoId, rule.RuleId, ruleData.LineNum); err != nil {
+			j.Abort()
+			return ico.Err(code, err.Error())
+		}
+		j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeRule, RepoId: rule.RepoId, Id: rule.RuleId, LineNum: ruleData.LineNum})
+		j.Done(step)
+	}
+	// 7.删除解析规则
+	for _, parserData := range deleteParsers {
+		step := j.Plan("删除解析规则",
+			journal.Call{Path: "/v1/rule-repo/parser/delete", Params: map[string]interface{}{"parser_repo_id": parserData.ParserRepoId, "parser_id": parserData.ParserId, "line_num": parserData.LineNum}},
+		)
+		// 上游没有解析规则新增接口，保留原始数据用于人工恢复
+		j.Keep(step, parserData.Raw)
+		if code, err = This.DeleteParser(token, parserData.ParserRepoId, parserData.ParserId, parserData.LineNum); err != nil {
+			j.Abort()
+			return ico.Err(code, err.Error())
+		}
+		j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeParser, RepoId: parserData.ParserRepoId, Id: parserData.ParserId, LineNum: parserData.LineNum})
+		j.Done(step)
+	}
+	// 8.删除标签
+	tag := tagRes.List[0]
+	step := j.Plan("删除标签",
+		journal.Call{Path: "/v1/tag/delete", Params: map[string]interface{}{"tagval_tbl_id": This.TagValTblId, "line_nums": []int{tag.LineNum}, "tagval_ids": []int{This.TagId}}},
+		journal.Call{Path: "/v1/tag/add", Params: map[string]interface{}{"tagval_tbl_id": This.TagValTblId, "data": []TagInfo{{Id: tag.Id, Name: tag.Name}}}},
+	)
+	if code, err := This.DeleteTag(token, tag.LineNum); err != nil {
+		j.Abort()
 		return ico.Err(code, err.Error())
 	}
-	message += fmt.Sprintf(" 删除标签：[%d]", This.TagId)
+	j.ChangeSet.Delete(response.ChangeItem{Type: response.ChangeTag, RepoId: This.TagValTblId, Id: This.TagId, LineNum: tag.LineNum})
+	j.Done(step)
+	message := j.ChangeSet.Summary()
 	logger.Info(message)
-	public.Commit(token, message)
-	return ico.Succ("删除成功")
+	audit.SetMessage(c, message)
+	if code, err := j.Commit(); err != nil {
+		j.Abort()
+		return ico.Err(code, err.Error())
+	}
+	return ico.Succ(response.WriteRes{Message: "删除成功", ChangeSet: *j.ChangeSet})
+}
+
+// Plan 删除预览
+func (This TagDelete) Plan(unlinkRules []UnlinkRule, deleteRules []public.RuleInfo, deleteParsers []public.ParserInfo, tag TagInfo) response.Plan {
+	plan := response.NewPlan()
+	for _, unlink := range unlinkRules {
+		plan.UnlinkRules = append(plan.UnlinkRules, response.PlanUnlinkRule{RepoId: unlink.RepoId, RuleId: unlink.RuleId, DimensionId: unlink.DimensionId, TagId: This.TagId})
+	}
+	for i, rule := range This.RuleList {
+		plan.DeleteRules = append(plan.DeleteRules, response.PlanRule{RepoId: rule.RepoId, RuleId: rule.RuleId, LineNum: deleteRules[i].LineNum})
+	}
+	for _, parser := range deleteParsers {
+		plan.DeleteParsers = append(plan.DeleteParsers, response.PlanParser{ParserRepoId: parser.ParserRepoId, ParserId: parser.ParserId, LineNum: parser.LineNum})
+	}
+	plan.DeleteTags = append(plan.DeleteTags, response.PlanTag{TagValTblId: This.TagValTblId, TagId: This.TagId, TagName: tag.Name, LineNum: tag.LineNum})
+	return plan
+}
+
+// 待解绑标签的规则
+type UnlinkRule struct {
+	RepoId      int `json:"repo_id"`
+	RuleId      int `json:"rule_id"`
+	DimensionId int `json:"dimension_id"`
+}
+
+func (u UnlinkRule) Params() map[string]interface{} {
+	return map[string]interface{}{
+		"repo_id": u.RepoId, "rule_id": u.RuleId, "dimensions": []map[string]interface{}{{"dimension_id": u.DimensionId}},
+	}
+}
+
+// LinkParams 重新绑定标签，用于补偿
+func (u UnlinkRule) LinkParams(tagId int) map[string]interface{} {
+	return map[string]interface{}{
+		"repo_id": u.RepoId, "rule_id": u.RuleId, "dimensions": []map[string]interface{}{{"dimension_id": u.DimensionId, "value_id": tagId}},
+	}
+}
+
+func isUnlinked(unlinkRules []UnlinkRule, repoId, ruleId, dimId int) bool {
+	for _, unlink := range unlinkRules {
+		if unlink.RepoId == repoId && unlink.RuleId == ruleId && unlink.DimensionId == dimId {
+			return true
+		}
+	}
+	return false
 }
 
 func (This *TagDelete) GetDimData(token string) (resData DimQueryRes, code int, err error) {
@@ -189,73 +310,6 @@ func (This TagDelete) GetRuleData(token string, repoId, dimId int) (resData Rule
 	return
 }
 
-func (This TagDelete) QueryRuleData(token string, repoId, ruleId int) (resData RuleQueryRes, code int, err error) {
-	// 1.整理入参
-	pars := map[string]interface{}{"repo_id": repoId, "type": 1, "data_type": 3, "rule_ids": []int{ruleId}}
-	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/rule/query", middleware.GetAddr("repo-service"))
-	headers := map[string]string{"X-Access-Token": token}
-	// 2.获取数据
-	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
-	if err != nil {
-		logger.Info("数据查询失败")
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	res := RuleRes{}
-	err = json.Unmarshal(respRepo, &res)
-	if err != nil {
-		logger.Info("数据查询失败", string(respRepo), pars)
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	if res.Code != 200 {
-		logger.Info("数据查询失败")
-		return resData, res.Code, errors.New(res.Message)
-	}
-	resData = res.Data
-	return
-}
-
-// 解析规则
-type ParserRes struct {
-	Code    int            `json:"code"`
-	Message string         `json:"message"`
-	Data    ParserQueryRes `json:"data"`
-}
-
-type ParserQueryRes struct {
-	List []ParserQueryInfo `json:"list"`
-}
-
-type ParserQueryInfo struct {
-	ParserId     int `json:"parser_id"`
-	LineNum      int `json:"line_num"`
-	ParserRepoId int `json:"parser_repo_id"`
-}
-
-func (This TagDelete) GetParserData(token string, repoId, parserId int) (resData ParserQueryRes, code int, err error) {
-	// 1.整理入参
-	pars := map[string]interface{}{"parser_repo_id": repoId, "type": 1, "parser_id": parserId}
-	urlRepo := fmt.Sprintf("http://%s/v1/parser-repo/parser/query", middleware.GetAddr("repo-service"))
-	headers := map[string]string{"X-Access-Token": token}
-	// 2.获取数据
-	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
-	if err != nil {
-		logger.Info("数据查询失败")
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	res := ParserRes{}
-	err = json.Unmarshal(respRepo, &res)
-	if err != nil {
-		logger.Info("数据查询失败", string(respRepo), pars)
-		return resData, 2301, errors.New("数据查询失败")
-	}
-	if res.Code != 200 {
-		logger.Info("数据查询失败")
-		return resData, res.Code, errors.New(res.Message)
-	}
-	resData = res.Data
-	return
-}
-
 // 规则标签
 type RuleTagRes struct {
 	Code    int     `json:"code"`
@@ -338,6 +392,7 @@ func (This TagDelete) DeleteParser(token string, repoId, parserId, lineNum int)
 	return
 }
 
+// GetTagData 查询待删除的标签，不存在时返回空列表
 func (This TagDelete) GetTagData(token string) (resData TagData, code int, err error) {
 	// 1.整理入参
 	pars := map[string]interface{}{"tagval_tbl_id": This.TagValTblId, "type": 1, "tagval_ids": []int{This.TagId}}
@@ -355,11 +410,14 @@ func (This TagDelete) GetTagData(token string) (resData TagData, code int, err e
 		logger.Info("数据查询失败", string(respRepo), pars)
 		return resData, 2301, errors.New("数据查询失败")
 	}
-	if res.Code != 200 || len(res.Data.List) == 0 {
+	if res.Code != 200 {
 		logger.Info("数据查询失败")
 		return resData, res.Code, errors.New(res.Message)
 	}
 	resData = res.Data
+	if resData.List == nil {
+		resData.List = []TagInfo{}
+	}
 	return
 }
 
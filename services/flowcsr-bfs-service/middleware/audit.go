@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/model/audit"
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// 请求体保存的最大长度
+const maxAuditBody = 65536
+
+type bodyWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Audit 记录增删改操作的审计日志
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsWrite(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		reqBody, _ := ioutil.ReadAll(c.Request.Body)
+		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+		writer := bodyWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+		c.Next()
+		// 整理结果
+		token := strings.Split(c.GetHeader("X-Access-Token"), ";")[0]
+		caller := GetCaller(token)
+		// 定时操作以服务账号执行，记录创建人
+		if creator := ScheduleCreator(c); creator.UserName != "" {
+			caller = creator
+		}
+		res := struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}{}
+		_ = json.Unmarshal(writer.body.Bytes(), &res)
+		record := &audit.Audit{
+			UserId: caller.UserId, UserName: caller.UserName, Route: c.Request.URL.Path, RequestBody: requestBody(c, reqBody),
+			Message: audit.GetMessage(c), Success: res.Code == 200, Code: res.Code,
+			Duration: time.Since(start).Milliseconds(), Refs: audit.GetRefs(c),
+		}
+		if !record.Success {
+			record.ErrMessage = res.Message
+		}
+		if err := audit.Save(record); err != nil {
+			logger.Error("审计记录保存失败 ", err.Error())
+		}
+	}
+}
+
+// requestBody 只保存json请求体，超长截断；文件上传只保存表单字段
+func requestBody(c *gin.Context, body []byte) string {
+	if c.ContentType() == gin.MIMEJSON {
+		if r := []rune(string(body)); len(r) > maxAuditBody {
+			return string(r[:maxAuditBody])
+		}
+		return string(body)
+	}
+	if c.Request.MultipartForm != nil {
+		b, _ := json.Marshal(c.Request.MultipartForm.Value)
+		return string(b)
+	}
+	return ""
+}
@@ -10,6 +10,9 @@ import (
 
 var opMutex sync.Mutex
 
+// 增删改操作的路由后缀
+var writeSuffixes = []string{"delete", "add-batch", "update-batch", "copy", "move", "status", "repair", "import", "save", "submit", "create", "cancel", "reserve"}
+
 func AuthToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("X-Access-Token") //cookie中拿到token
@@ -24,17 +27,49 @@ func AuthToken() gin.HandlerFunc {
 			return
 		}
 		// 增删改操作需要入队
-		url := c.Request.RequestURI
-		if !strings.HasSuffix(url, "delete") && !strings.HasSuffix(url, "add-batch") {
+		if !IsWrite(c.Request.URL.Path) {
 			c.Next()
 			return
 		}
-		// 增删改操作入队，加锁
-		item := queue.Item{C: c.Copy()}
-		queue.Q().Enqueue(item)
+		// 服务关闭中不再接受写操作
+		id, ok := writes.beginWrite()
+		if !ok {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  0,
+				"code":    503,
+				"message": "服务正在关闭，请稍后重试",
+				"data":    map[string]string{},
+			})
+			c.Abort()
+			return
+		}
+		defer writes.endWrite(id)
+		// 增删改操作入队，加锁，后续处理在锁内执行
+		queue.Q().Enqueue(queue.Item{C: c})
 		opMutex.Lock()
-		item = queue.Q().Dequeue()
-		item.C.Next()
-		opMutex.Unlock()
+		defer opMutex.Unlock()
+		queue.Q().Dequeue()
+		// 排队期间关闭服务超时，不再执行
+		if WriteContext().Err() != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  0,
+				"code":    503,
+				"message": "服务正在关闭，请稍后重试",
+				"data":    map[string]string{},
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// IsWrite 是否为增删改操作
+func IsWrite(url string) bool {
+	for _, suffix := range writeSuffixes {
+		if strings.HasSuffix(url, suffix) {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"context"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// Caller 调用方身份，从token中解析，签名校验不通过或未配置密钥时为空
+type Caller struct {
+	UserId    int       `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	ExpiresAt time.Time `json:"-"` // token过期时间，未设置exp时为零值
+}
+
+func GetCaller(token string) (caller Caller) {
+	if config.ApplicationConfig.JwtSecret == "" {
+		return
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("不支持的签名算法：%v", t.Header["alg"])
+		}
+		return []byte(config.ApplicationConfig.JwtSecret), nil
+	})
+	if err != nil {
+		return
+	}
+	for _, key := range []string{"user_id", "uid", "id"} {
+		if v, ok := claims[key].(float64); ok {
+			caller.UserId = int(v)
+			break
+		}
+	}
+	if v, ok := claims["exp"].(float64); ok {
+		caller.ExpiresAt = time.Unix(int64(v), 0)
+	}
+	for _, key := range []string{"account", "user_name", "username", "name"} {
+		if v, ok := claims[key]; ok {
+			caller.UserName = fmt.Sprint(v)
+			break
+		}
+	}
+	return
+}
+
+type scheduleCreatorKey struct{}
+
+// WithScheduleCreator 定时操作以服务账号执行时记录创建人，只能在服务内部构造请求时设置
+func WithScheduleCreator(ctx context.Context, creator Caller) context.Context {
+	return context.WithValue(ctx, scheduleCreatorKey{}, creator)
+}
+
+// ScheduleCreator 定时操作的创建人，非定时操作时为空
+func ScheduleCreator(c *gin.Context) Caller {
+	creator, _ := c.Request.Context().Value(scheduleCreatorKey{}).(Caller)
+	return creator
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+func TestGetCaller(t *testing.T) {
+	config.ApplicationConfig = &config.Application{JwtSecret: "secret"}
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 7, "account": "alice", "exp": exp.Unix()}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := GetCaller(token)
+	if caller.UserId != 7 || caller.UserName != "alice" || !caller.ExpiresAt.Equal(exp) {
+		t.Fatalf("GetCaller = %+v", caller)
+	}
+	config.ApplicationConfig = &config.Application{JwtSecret: "other"}
+	if caller := GetCaller(token); caller.UserName != "" {
+		t.Fatalf("GetCaller with another secret = %+v", caller)
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 写操作上下文取消后，等待进行中的写操作撤销暂存区的时间
+const abortTimeout = 30 * time.Second
+
+// 进行中的增删改操作，用于关闭服务时等待其提交或撤销
+var writes = newWriteTracker()
+
+// ErrWriteAborted 关闭服务超时，写操作上下文已取消
+var ErrWriteAborted = errors.New("服务正在关闭，写操作已撤销")
+
+type writeTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	seq     int64
+	running map[int64]bool
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newWriteTracker() *writeTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &writeTracker{running: map[int64]bool{}, ctx: ctx, cancel: cancel}
+}
+
+// WriteContext 写操作上下文，关闭服务超时后取消；写操作调用上游及提交前需检查，取消后以用户token撤销暂存区
+func WriteContext() context.Context {
+	return writes.ctx
+}
+
+// beginWrite 登记写操作，服务关闭中返回false
+func (w *writeTracker) beginWrite() (id int64, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, false
+	}
+	w.seq++
+	w.running[w.seq] = true
+	w.wg.Add(1)
+	return w.seq, true
+}
+
+func (w *writeTracker) endWrite(id int64) {
+	w.mu.Lock()
+	delete(w.running, id)
+	w.mu.Unlock()
+	w.wg.Done()
+}
+
+// StopWrites 不再接受新的写操作
+func StopWrites() {
+	writes.mu.Lock()
+	writes.closed = true
+	writes.mu.Unlock()
+}
+
+// DrainWrites 等待进行中的写操作结束；超时后取消写操作上下文，写操作在下一次调用上游或提交前撤销暂存区，
+// 再等待abortTimeout后返回仍未结束的数量，由下次启动时的恢复流程处理
+func DrainWrites(ctx context.Context) (unfinished int) {
+	StopWrites()
+	done := make(chan struct{})
+	go func() {
+		writes.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	writes.cancel()
+	select {
+	case <-done:
+		return
+	case <-time.After(abortTimeout):
+	}
+	writes.mu.Lock()
+	defer writes.mu.Unlock()
+	return len(writes.running)
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainWritesAbort(t *testing.T) {
+	id, ok := writes.beginWrite()
+	if !ok {
+		t.Fatal("beginWrite should succeed before shutdown")
+	}
+	// 模拟写操作：上下文取消后撤销暂存区再结束
+	go func() {
+		<-WriteContext().Done()
+		writes.endWrite(id)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if unfinished := DrainWrites(ctx); unfinished != 0 {
+		t.Fatalf("unfinished = %d, want 0", unfinished)
+	}
+	if WriteContext().Err() == nil {
+		t.Fatal("write context should be cancelled after drain timeout")
+	}
+	if _, ok := writes.beginWrite(); ok {
+		t.Fatal("beginWrite should fail after shutdown")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/micro/go-micro/v2/registry"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"time"
 )
 
@@ -69,7 +70,7 @@ func GetUrl(params map[string]interface{}, url string, headext ...map[string]str
 	}
 	url += "?"
 	for k, v := range params {
-		url += fmt.Sprintf("%s=%s&", k, fmt.Sprint(v))
+		url += fmt.Sprintf("%s=%s&", k, neturl.QueryEscape(fmt.Sprint(v)))
 	}
 	resqbyte, err := json.Marshal(params)
 	if err != nil {
@@ -1,6 +1,9 @@
 package queue
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"sync"
+)
 
 var __q *ItemQueue
 
@@ -18,6 +21,7 @@ type Item struct {
 
 // Item the type of the queue
 type ItemQueue struct {
+	mu    sync.Mutex
 	items []Item
 }
 
@@ -30,13 +34,17 @@ type ItemQueuer interface {
 
 // Enqueue adds an Item to the end of the queue
 func (s *ItemQueue) Enqueue(t Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.items = append(s.items, t)
 }
 
 // dequeue
 func (s *ItemQueue) Dequeue() Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	item := s.items[0] // 先进先出
-	if s.Size() == 1 {
+	if len(s.items) == 1 {
 		s.items = []Item{}
 	} else {
 		s.items = s.items[1:len(s.items)]
@@ -45,10 +53,12 @@ func (s *ItemQueue) Dequeue() Item {
 }
 
 func (s *ItemQueue) IsEmpty() bool {
-	return len(s.items) == 0
+	return s.Size() == 0
 }
 
 // Size returns the number of Items in the queue
 func (s *ItemQueue) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return len(s.items)
 }
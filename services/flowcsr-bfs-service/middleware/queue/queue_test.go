@@ -1,7 +1,7 @@
 package queue
 
 import (
-	"fmt"
+	"github.com/gin-gonic/gin"
 	"testing"
 )
 
@@ -9,24 +9,20 @@ func TestName(t *testing.T) {
 
 	InitQ()
 
-	item := Item{
-		EnvId:       1,
-		TaskId:      1,
-		RuleVersion: "ruleVersion",
-		RepoList:    []CompileRepo{},
+	first := Item{C: &gin.Context{}}
+	Q().Enqueue(first)
+	second := Item{C: &gin.Context{}}
+	Q().Enqueue(second)
+	if Q().Size() != 2 {
+		t.Fatalf("size = %d, want 2", Q().Size())
 	}
-	Q().Enqueue(item)
-	item = Item{
-		EnvId:       2,
-		TaskId:      2,
-		RuleVersion: "ruleVersion",
-		RepoList:    []CompileRepo{},
+	if a := Q().Dequeue(); a.C != first.C {
+		t.Fatal("dequeue order is not FIFO")
+	}
+	if a := Q().Dequeue(); a.C != second.C {
+		t.Fatal("dequeue order is not FIFO")
+	}
+	if !Q().IsEmpty() {
+		t.Fatal("queue should be empty")
 	}
-	Q().Enqueue(item)
-	a := Q().Dequeue()
-	fmt.Println(a.EnvId)
-	fmt.Println(a.TaskId)
-	a = Q().Dequeue()
-	fmt.Println(a.EnvId)
-	fmt.Println(a.TaskId)
 }
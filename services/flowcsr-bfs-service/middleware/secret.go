@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// secretCipher 以jwt_secret派生的密钥加密需要落库的用户token
+func secretCipher() (cipher.AEAD, error) {
+	if config.ApplicationConfig.JwtSecret == "" {
+		return nil, errors.New("未配置jwt_secret")
+	}
+	key := sha256.Sum256([]byte(config.ApplicationConfig.JwtSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal 加密用户token，结果可落库
+func Seal(token string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+// Unseal 解密Seal加密的用户token
+func Unseal(sealed string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", errors.New("token格式错误")
+	}
+	token, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("token解密失败")
+	}
+	return string(token), nil
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"bigrule/services/flowcsr-bfs-service/config"
+	"strings"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	config.ApplicationConfig = &config.Application{JwtSecret: "secret"}
+	sealed, err := Seal("token-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "token-a") {
+		t.Fatalf("sealed token contains plain text: %s", sealed)
+	}
+	if token, err := Unseal(sealed); err != nil || token != "token-a" {
+		t.Fatalf("Unseal = %q, %v", token, err)
+	}
+	config.ApplicationConfig = &config.Application{JwtSecret: "other"}
+	if _, err := Unseal(sealed); err == nil {
+		t.Fatal("Unseal with another secret should fail")
+	}
+	config.ApplicationConfig = &config.Application{}
+	if _, err := Seal("token-a"); err == nil {
+		t.Fatal("Seal without jwt_secret should fail")
+	}
+}
@@ -36,14 +36,14 @@ func GetPermission(token string) (permissionToken Permission, code int, err erro
 	}
 	permissionToken.RepoIds = repoIds
 	// 2.获取新token
-	//newToken, code, err := getUser()
+	//newToken, code, err := GetUser()
 	//if err != nil {
 	//	return
 	//}
 	newToken := token
 	permissionToken.Token = newToken
 	// 3.获取标签表权限
-	dimRes, code, err := getDimData(newToken, repoIds)
+	dimRes, code, err := GetDimData(newToken, repoIds)
 	if err != nil {
 		return
 	}
@@ -114,7 +114,8 @@ type UserRes struct {
 	Token  string `json:"token"`
 }
 
-func getUser() (token string, code int, err error) {
+// GetUser 服务账号登录，返回服务token
+func GetUser() (token string, code int, err error) {
 	pars := map[string]interface{}{"account": config.UserConfig.Name, "password": config.UserConfig.Pwd}
 	url := fmt.Sprintf("http://%s/v2/users/login", GetAddr("authentication-service"))
 	headers := map[string]string{"X-Access-Token": token}
@@ -149,6 +150,7 @@ type DimQueryRes struct {
 
 type DimInfo struct {
 	RepoId       int        `json:"repo_id"`
+	DimensionId  int        `json:"dimension_id"`
 	DictionaryId int        `json:"dictionary_id"`
 	TagValMsg    TagValInfo `json:"tagval_msg"`
 }
@@ -157,7 +159,8 @@ type TagValInfo struct {
 	Id int `json:"id"`
 }
 
-func getDimData(token string, repo_ids []int) (resData DimQueryRes, code int, err error) {
+// GetDimData 查询规则库的维度及关联的标签表
+func GetDimData(token string, repo_ids []int) (resData DimQueryRes, code int, err error) {
 	// 1.整理入参
 	pars := map[string]interface{}{"repo_ids": repo_ids, "type": 1}
 	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/dimension/query", GetAddr("repo-service"))
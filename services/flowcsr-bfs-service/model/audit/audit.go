@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bigrule/common/global"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 关联对象类型
+const (
+	KindRepo   = "repo"
+	KindRule   = "rule"
+	KindTag    = "tag"
+	KindParser = "parser"
+	// 权限范围，查询时按调用方有权限的规则库、解析规则库、标签表过滤
+	KindParserRepo = "parser_repo"
+	KindTagTable   = "tag_table"
+)
+
+const (
+	keyMessage = "audit_message"
+	keyRefs    = "audit_refs"
+)
+
+// Audit 写操作审计记录
+type Audit struct {
+	Id          int       `json:"id"            gorm:"primaryKey;autoIncrement"`
+	UserId      int       `json:"user_id"       gorm:"index"`
+	UserName    string    `json:"user_name"     gorm:"size:64;index"`
+	Route       string    `json:"route"         gorm:"size:128"`
+	RequestBody string    `json:"request_body"  gorm:"type:mediumtext"`
+	Message     string    `json:"message"       gorm:"type:text"`
+	Success     bool      `json:"success"`
+	Code        int       `json:"code"`
+	ErrMessage  string    `json:"err_message"   gorm:"type:text"`
+	Duration    int64     `json:"duration"` // 毫秒
+	CreatedAt   time.Time `json:"created_at"    gorm:"index"`
+	Refs        []Ref     `json:"refs"          gorm:"foreignKey:AuditId"`
+}
+
+func (Audit) TableName() string {
+	return "bfs_audit"
+}
+
+// Ref 审计记录关联的规则库、规则、标签、解析规则
+type Ref struct {
+	Id      int    `json:"-"        gorm:"primaryKey;autoIncrement"`
+	AuditId int    `json:"-"        gorm:"index"`
+	Kind    string `json:"kind"     gorm:"size:16;index:idx_kind_ref"`
+	RefId   int    `json:"ref_id"   gorm:"index:idx_kind_ref"`
+}
+
+func (Ref) TableName() string {
+	return "bfs_audit_ref"
+}
+
+// Migrate 建表
+func Migrate() error {
+	return global.DBMysql.AutoMigrate(&Audit{}, &Ref{})
+}
+
+// SetMessage 记录本次写操作的提交信息
+func SetMessage(c *gin.Context, message string) {
+	c.Set(keyMessage, message)
+}
+
+// AddRefs 记录本次写操作涉及的对象id
+func AddRefs(c *gin.Context, kind string, ids ...int) {
+	refs := GetRefs(c)
+	for _, id := range ids {
+		refs = append(refs, Ref{Kind: kind, RefId: id})
+	}
+	c.Set(keyRefs, refs)
+}
+
+func GetMessage(c *gin.Context) string {
+	return c.GetString(keyMessage)
+}
+
+func GetRefs(c *gin.Context) []Ref {
+	if refs, ok := c.Get(keyRefs); ok {
+		return refs.([]Ref)
+	}
+	return []Ref{}
+}
+
+// Save 保存审计记录，同一对象只记录一次
+func Save(record *Audit) error {
+	refs := []Ref{}
+	exists := map[Ref]bool{}
+	for _, ref := range record.Refs {
+		if exists[ref] {
+			continue
+		}
+		exists[ref] = true
+		refs = append(refs, ref)
+	}
+	record.Refs = refs
+	return global.DBMysql.Create(record).Error
+}
+
+// Filter 审计查询条件
+type Filter struct {
+	UserName  string
+	UserId    int
+	RepoId    int
+	RuleId    int
+	TagId     int
+	StartTime time.Time
+	EndTime   time.Time
+	PageSize  int
+	PageIndex int
+	Scope     *Scope
+}
+
+// Scope 调用方可见范围：关联的规则库、解析规则库、标签表均有权限，或本人的操作
+type Scope struct {
+	UserId        int
+	RepoIds       []int
+	ParserRepoIds []int
+	TagTblIds     []int
+}
+
+// Query 按条件分页查询审计记录
+func Query(filter Filter) (list []Audit, total int64, err error) {
+	db := global.DBMysql.Model(&Audit{})
+	if filter.UserName != "" {
+		db = db.Where("user_name = ?", filter.UserName)
+	}
+	if filter.UserId != 0 {
+		db = db.Where("user_id = ?", filter.UserId)
+	}
+	refFilters := map[string]int{KindRepo: filter.RepoId, KindRule: filter.RuleId, KindTag: filter.TagId}
+	for kind, refId := range refFilters {
+		if refId == 0 {
+			continue
+		}
+		sub := global.DBMysql.Model(&Ref{}).Select("audit_id").Where("kind = ? AND ref_id = ?", kind, refId)
+		db = db.Where("id IN (?)", sub)
+	}
+	if filter.Scope != nil {
+		db = filter.Scope.apply(db)
+	}
+	if !filter.StartTime.IsZero() {
+		db = db.Where("created_at >= ?", filter.StartTime)
+	}
+	if !filter.EndTime.IsZero() {
+		db = db.Where("created_at <= ?", filter.EndTime)
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Preload("Refs").Order("id DESC").
+		Offset((filter.PageIndex - 1) * filter.PageSize).Limit(filter.PageSize).Find(&list).Error
+	return
+}
+
+func (s Scope) apply(db *gorm.DB) *gorm.DB {
+	// id从1开始，补0避免NOT IN空列表
+	kinds := map[string][]int{KindRepo: s.RepoIds, KindParserRepo: s.ParserRepoIds, KindTagTable: s.TagTblIds}
+	scoped := global.DBMysql.Model(&Ref{}).Select("audit_id").Where("kind IN ?", []string{KindRepo, KindParserRepo, KindTagTable})
+	forbidden := global.DBMysql.Model(&Ref{}).Select("audit_id")
+	cond := global.DBMysql
+	for _, kind := range []string{KindRepo, KindParserRepo, KindTagTable} {
+		cond = cond.Or("kind = ? AND ref_id NOT IN ?", kind, append([]int{0}, kinds[kind]...))
+	}
+	forbidden = forbidden.Where(cond)
+	if s.UserId != 0 {
+		return db.Where("user_id = ? OR (id IN (?) AND id NOT IN (?))", s.UserId, scoped, forbidden)
+	}
+	return db.Where("id IN (?) AND id NOT IN (?)", scoped, forbidden)
+}
@@ -0,0 +1,154 @@
+package history
+
+import (
+	"bigrule/common/global"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// 变更类型
+const (
+	ActionAdd    = "add"
+	ActionUpdate = "update"
+	ActionStatus = "status"
+	ActionDelete = "delete"
+)
+
+// RuleHistory 单条规则的一次变更，Before、After为规则快照json
+type RuleHistory struct {
+	Id        int       `json:"id"          gorm:"primaryKey;autoIncrement"`
+	JournalId int       `json:"journal_id"  gorm:"index"`
+	RepoId    int       `json:"repo_id"     gorm:"index:idx_repo_rule"`
+	RuleId    int       `json:"rule_id"     gorm:"index:idx_repo_rule"`
+	LineNum   int       `json:"line_num"`
+	Action    string    `json:"action"      gorm:"size:16"`
+	Fields    string    `json:"fields"      gorm:"size:255"`
+	Before    string    `json:"before"      gorm:"type:text"`
+	After     string    `json:"after"       gorm:"type:text"`
+	Operation string    `json:"operation"   gorm:"size:64"`
+	Message   string    `json:"message"     gorm:"type:text"`
+	UserId    int       `json:"user_id"`
+	UserName  string    `json:"user_name"   gorm:"size:64"`
+	CreatedAt time.Time `json:"created_at"  gorm:"index"`
+}
+
+func (RuleHistory) TableName() string {
+	return "bfs_rule_history"
+}
+
+// Migrate 建表
+func Migrate() error {
+	return global.DBMysql.AutoMigrate(&RuleHistory{})
+}
+
+// Snapshots 写操作前的规则快照，按规则库、规则id索引
+type Snapshots map[[2]int]public.RuleInfo
+
+func (s Snapshots) Add(repoId int, rules ...public.RuleInfo) {
+	for _, rule := range rules {
+		s[[2]int{repoId, rule.RuleId}] = rule
+	}
+}
+
+// Save 提交后按变更记录规则历史，新增、修改的规则重新查询提交后的快照
+func Save(token string, journalId int, changeSet *response.ChangeSet, before Snapshots) {
+	caller := middleware.GetCaller(token)
+	message := changeSet.Summary()
+	after := Snapshots{}
+	repoIds := map[int][]int{}
+	for _, items := range [][]response.ChangeItem{changeSet.Added, changeSet.Updated} {
+		for _, item := range items {
+			if item.Type == response.ChangeRule {
+				repoIds[item.RepoId] = append(repoIds[item.RepoId], item.Id)
+			}
+		}
+	}
+	for repoId, ruleIds := range repoIds {
+		ruleDataList, _, err := public.GetRules(token, repoId, ruleIds)
+		if err != nil {
+			logger.Error("规则历史快照查询失败 ", err.Error())
+			continue
+		}
+		after.Add(repoId, ruleDataList.List...)
+	}
+	list := []RuleHistory{}
+	add := func(item response.ChangeItem, action string, b, a *public.RuleInfo) {
+		fields, _ := json.Marshal(item.Fields)
+		list = append(list, RuleHistory{
+			JournalId: journalId, RepoId: item.RepoId, RuleId: item.Id, LineNum: item.LineNum, Action: action,
+			Fields: string(fields), Before: encode(b), After: encode(a), Operation: changeSet.Operation,
+			Message: message, UserId: caller.UserId, UserName: caller.UserName,
+		})
+	}
+	for _, item := range changeSet.Added {
+		if item.Type == response.ChangeRule {
+			add(item, ActionAdd, nil, lookup(after, item))
+		}
+	}
+	for _, item := range changeSet.Updated {
+		if item.Type == response.ChangeRule {
+			b, a := lookup(before, item), lookup(after, item)
+			add(item, updateAction(b, a), b, a)
+		}
+	}
+	for _, item := range changeSet.Deleted {
+		if item.Type == response.ChangeRule {
+			add(item, ActionDelete, lookup(before, item), nil)
+		}
+	}
+	if len(list) == 0 {
+		return
+	}
+	if err := global.DBMysql.Create(&list).Error; err != nil {
+		logger.Error("规则历史保存失败 ", err.Error())
+	}
+}
+
+// Query 规则变更时间线，按时间倒序
+func Query(repoId, ruleId, pageSize, pageIndex int) (list []RuleHistory, total int64, err error) {
+	db := global.DBMysql.Model(&RuleHistory{}).Where("repo_id = ? AND rule_id = ?", repoId, ruleId)
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("id DESC").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	return
+}
+
+func lookup(s Snapshots, item response.ChangeItem) *public.RuleInfo {
+	if rule, ok := s[[2]int{item.RepoId, item.Id}]; ok {
+		return &rule
+	}
+	return nil
+}
+
+// updateAction 仅状态变化时记为状态修改
+func updateAction(before, after *public.RuleInfo) string {
+	if before == nil || after == nil {
+		return ActionUpdate
+	}
+	b, a := before.Message(), after.Message()
+	if b.Attr.Status == a.Attr.Status {
+		return ActionUpdate
+	}
+	b.Attr.Status = a.Attr.Status
+	if reflect.DeepEqual(b, a) {
+		return ActionStatus
+	}
+	return ActionUpdate
+}
+
+func encode(rule *public.RuleInfo) string {
+	if rule == nil {
+		return ""
+	}
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,246 @@
+package journal
+
+import (
+	"bigrule/common/global"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/history"
+	"bigrule/services/flowcsr-bfs-service/model/public"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 日志状态
+const (
+	StatusRunning     = "running"     // 执行中
+	StatusCommitted   = "committed"   // 已提交
+	StatusCancelled   = "cancelled"   // 已撤销
+	StatusCompensated = "compensated" // 已补偿
+	StatusFailed      = "failed"      // 回滚失败，需人工处理
+)
+
+// 步骤状态
+const (
+	StepPlanned     = "planned"
+	StepDone        = "done"
+	StepCompensated = "compensated"
+	StepFailed      = "failed"
+)
+
+// Journal 写操作日志，一次增删改请求对应一条
+type Journal struct {
+	Id        int                 `json:"id"         gorm:"primaryKey;autoIncrement"`
+	Operation string              `json:"operation"  gorm:"size:64"`
+	Token     string              `json:"-"          gorm:"-"`
+	Sealed    string              `json:"-"          gorm:"type:text"` // 加密后的用户token，异常退出后撤销用，结束后清空
+	Status    string              `json:"status"     gorm:"size:16;index"`
+	Message   string              `json:"message"    gorm:"type:text"`
+	Changes   string              `json:"-"          gorm:"type:mediumtext"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	Steps     []*Step             `json:"steps"      gorm:"-"`
+	ChangeSet *response.ChangeSet `json:"change_set" gorm:"-"`
+	Before    history.Snapshots   `json:"-"          gorm:"-"`
+}
+
+func (Journal) TableName() string {
+	return "bfs_journal"
+}
+
+// Step 写操作步骤，记录正向调用及其补偿调用
+type Step struct {
+	Id         int       `json:"id"          gorm:"primaryKey;autoIncrement"`
+	JournalId  int       `json:"journal_id"  gorm:"index"`
+	Seq        int       `json:"seq"`
+	Action     string    `json:"action"      gorm:"size:64"`
+	Forward    string    `json:"forward"     gorm:"type:text"`
+	Compensate string    `json:"compensate"  gorm:"type:text"`
+	Status     string    `json:"status"      gorm:"size:16"`
+	Snapshot   string    `json:"snapshot"    gorm:"type:text"` // 无补偿接口时保留修改前数据，用于人工恢复
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (Step) TableName() string {
+	return "bfs_journal_step"
+}
+
+// Call repo-service 接口调用
+type Call struct {
+	Path   string      `json:"path"`
+	Params interface{} `json:"params"`
+}
+
+// Begin 开始记录一次写操作
+func Begin(token, operation string) *Journal {
+	j := &Journal{Operation: operation, Token: token, Status: StatusRunning, ChangeSet: response.NewChangeSet(operation), Before: history.Snapshots{}}
+	sealed, err := middleware.Seal(token)
+	if err != nil {
+		logger.Error("写操作token加密失败，异常退出后需人工处理 ", err.Error())
+	}
+	j.Sealed = sealed
+	if err := global.DBMysql.Create(j).Error; err != nil {
+		logger.Error("写操作日志创建失败 ", err.Error())
+	}
+	return j
+}
+
+// Plan 登记计划步骤，compensate为该步骤的补偿调用，按顺序执行
+func (j *Journal) Plan(action string, forward Call, compensate ...Call) *Step {
+	step := &Step{JournalId: j.Id, Seq: len(j.Steps) + 1, Action: action, Status: StepPlanned}
+	step.Forward = encode(forward)
+	step.Compensate = encode(compensate)
+	j.Steps = append(j.Steps, step)
+	if err := global.DBMysql.Create(step).Error; err != nil {
+		logger.Error("写操作步骤记录失败 ", err.Error())
+	}
+	return step
+}
+
+// SetCompensate 执行后才能确定补偿调用时（如新增后才有行号）更新补偿调用
+func (j *Journal) SetCompensate(step *Step, compensate ...Call) {
+	step.Compensate = encode(compensate)
+	j.save(step)
+}
+
+// Keep 保留步骤修改前的数据，用于上游没有对应补偿接口的步骤
+func (j *Journal) Keep(step *Step, snapshot interface{}) {
+	step.Snapshot = encode(snapshot)
+	j.save(step)
+}
+
+// Done 步骤执行完成，同时保存当前变更
+func (j *Journal) Done(step *Step) {
+	step.Status = StepDone
+	j.save(step)
+	j.setStatus(StatusRunning)
+}
+
+// Commit 提交本次写操作，变更由调用方在执行过程中记录到ChangeSet；关闭服务超时后不再提交，由调用方撤销
+func (j *Journal) Commit() (code int, err error) {
+	if middleware.WriteContext().Err() != nil {
+		return 503, middleware.ErrWriteAborted
+	}
+	if code, err = public.Commit(j.Token, j.ChangeSet); err != nil {
+		return
+	}
+	j.setStatus(StatusCommitted)
+	history.Save(j.Token, j.Id, j.ChangeSet, j.Before)
+	return
+}
+
+// Snapshot 登记规则修改、删除前的快照，提交后用于记录规则历史
+func (j *Journal) Snapshot(repoId int, rules ...public.RuleInfo) {
+	j.Before.Add(repoId, rules...)
+}
+
+// Abort 撤销本次写操作，撤销失败或不可用时按倒序执行已完成步骤的补偿调用
+func (j *Journal) Abort() {
+	if _, err := public.Cancel(j.Token); err == nil {
+		j.setStatus(StatusCancelled)
+		return
+	}
+	logger.Error(fmt.Sprintf("写操作[%d]撤销失败，开始补偿", j.Id))
+	j.compensate(j.Token)
+}
+
+func (j *Journal) compensate(token string) {
+	status := StatusCompensated
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		step := j.Steps[i]
+		if step.Status != StepDone {
+			continue
+		}
+		calls := []Call{}
+		if err := json.Unmarshal([]byte(step.Compensate), &calls); err != nil {
+			logger.Error(fmt.Sprintf("写操作[%d]步骤[%d]补偿解析失败", j.Id, step.Seq))
+		}
+		step.Status = StepCompensated
+		for _, call := range calls {
+			if _, err := exec(token, call); err != nil {
+				logger.Error(fmt.Sprintf("写操作[%d]步骤[%d]补偿失败 %s", j.Id, step.Seq, err.Error()))
+				step.Status = StepFailed
+				status = StatusFailed
+			}
+		}
+		if len(calls) == 0 {
+			logger.Error(fmt.Sprintf("写操作[%d]步骤[%d]无补偿调用，需按快照人工恢复", j.Id, step.Seq))
+			step.Status = StepFailed
+			status = StatusFailed
+		}
+		j.save(step)
+	}
+	// 补偿调用同样处于暂存状态，需要提交
+	if _, err := public.Commit(token, response.NewChangeSet(fmt.Sprintf("%s 回滚", j.Operation))); err != nil {
+		status = StatusFailed
+	}
+	j.setStatus(status)
+}
+
+func (j *Journal) save(step *Step) {
+	if step.Id == 0 {
+		return
+	}
+	if err := global.DBMysql.Save(step).Error; err != nil {
+		logger.Error("写操作步骤更新失败 ", err.Error())
+	}
+}
+
+func (j *Journal) setStatus(status string) {
+	j.Status = status
+	j.Message = j.ChangeSet.Summary()
+	j.Changes = encode(j.ChangeSet)
+	if j.Id == 0 {
+		return
+	}
+	values := map[string]interface{}{"status": status, "message": j.Message, "changes": j.Changes}
+	if status != StatusRunning {
+		j.Sealed = ""
+		values["sealed"] = ""
+	}
+	err := global.DBMysql.Model(j).Updates(values).Error
+	if err != nil {
+		logger.Error("写操作日志更新失败 ", err.Error())
+	}
+}
+
+func encode(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("写操作参数序列化失败 ", err.Error())
+		return ""
+	}
+	return string(b)
+}
+
+// Exec 调用 repo-service 接口；关闭服务超时后不再调用，由调用方撤销
+func Exec(token string, call Call) (code int, err error) {
+	if middleware.WriteContext().Err() != nil {
+		return 503, middleware.ErrWriteAborted
+	}
+	return exec(token, call)
+}
+
+// exec 调用 repo-service 接口，补偿调用在关闭服务时也需执行
+func exec(token string, call Call) (code int, err error) {
+	url := fmt.Sprintf("http://%s%s", middleware.GetAddr("repo-service"), call.Path)
+	headers := map[string]string{"X-Access-Token": token}
+	resp, err := middleware.PostUrl(call.Params, url, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return 2301, errors.New("数据查询失败")
+	}
+	res := response.CsrRes{}
+	if err = json.Unmarshal(resp, &res); err != nil {
+		logger.Info("数据查询失败", string(resp), call.Params)
+		return 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败", call.Path)
+		return res.Code, errors.New(res.Message)
+	}
+	return
+}
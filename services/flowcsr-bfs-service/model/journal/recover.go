@@ -0,0 +1,62 @@
+package journal
+
+import (
+	"bigrule/common/global"
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Migrate 建表，删除旧版本保存token原文的列
+func Migrate() error {
+	if err := global.DBMysql.AutoMigrate(&Journal{}, &Step{}); err != nil {
+		return err
+	}
+	if migrator := global.DBMysql.Migrator(); migrator.HasColumn(&Journal{}, "token") {
+		return migrator.DropColumn(&Journal{}, "token")
+	}
+	return nil
+}
+
+// Recover 启动时处理上次异常退出遗留的写操作，需在接受请求前同步执行；只处理before之前创建的。
+// 以原用户token撤销其暂存区，撤销失败时以同一token补偿；token不可用时标记为失败，需人工处理，不以其他身份补偿
+func Recover(before time.Time) {
+	list := []*Journal{}
+	err := global.DBMysql.Where("status = ? AND created_at < ?", StatusRunning, before).Order("id").Find(&list).Error
+	if err != nil {
+		logger.Error("未完成写操作查询失败 ", err.Error())
+		return
+	}
+	for _, j := range list {
+		if err := j.load(); err != nil {
+			continue
+		}
+		token, err := middleware.Unseal(j.Sealed)
+		if err != nil {
+			logger.Error(fmt.Sprintf("写操作[%d]用户token不可用，需人工处理 %s", j.Id, err.Error()))
+			j.setStatus(StatusFailed)
+			continue
+		}
+		j.Token = token
+		j.Abort()
+		logger.Info(fmt.Sprintf("写操作[%d]已处理，状态：%s", j.Id, j.Status))
+	}
+}
+
+// load 加载步骤及已记录的变更
+func (j *Journal) load() error {
+	if err := global.DBMysql.Where("journal_id = ?", j.Id).Order("seq").Find(&j.Steps).Error; err != nil {
+		logger.Error(fmt.Sprintf("写操作[%d]步骤查询失败 %s", j.Id, err.Error()))
+		return err
+	}
+	j.ChangeSet = response.NewChangeSet(j.Operation)
+	if j.Changes != "" {
+		if err := json.Unmarshal([]byte(j.Changes), j.ChangeSet); err != nil {
+			logger.Error(fmt.Sprintf("写操作[%d]变更解析失败 %s", j.Id, err.Error()))
+		}
+	}
+	return nil
+}
@@ -5,21 +5,27 @@ import (
 	"bigrule/services/flowcsr-bfs-service/middleware"
 	"bigrule/services/flowcsr-bfs-service/model/response"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
-func Cancel(token string) {
+func Cancel(token string) (code int, err error) {
 	pars := map[string]interface{}{}
 	url := fmt.Sprintf("http://%s/v1/public/cancel", middleware.GetAddr("repo-service"))
 	headers := map[string]string{"X-Access-Token": token}
 	resp, err := middleware.PostUrl(pars, url, headers)
 	if err != nil {
 		logger.Error("请求异常")
-		return
+		return 2301, errors.New("撤销请求异常")
 	}
 	res := response.CsrRes{}
 	if err = json.Unmarshal(resp, &res); err != nil {
 		logger.Error(string(resp), " json解析异常")
-		return
+		return 2301, errors.New("撤销结果解析异常")
 	}
+	if res.Code != 200 {
+		logger.Error("撤销失败 ", res.Message)
+		return res.Code, errors.New(res.Message)
+	}
+	return
 }
@@ -5,21 +5,28 @@ import (
 	"bigrule/services/flowcsr-bfs-service/middleware"
 	"bigrule/services/flowcsr-bfs-service/model/response"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
-func Commit(token, message string) {
-	pars := map[string]interface{}{"message": message}
+// Commit 提交暂存的修改，json请求体中message为可读摘要，change_set为结构化变更
+func Commit(token string, changeSet *response.ChangeSet) (code int, err error) {
+	pars := map[string]interface{}{"message": changeSet.Summary(), "change_set": changeSet}
 	url := fmt.Sprintf("http://%s/v1/public/public", middleware.GetAddr("repo-service"))
 	headers := map[string]string{"X-Access-Token": token}
-	resp, err := middleware.GetUrl(pars, url, headers)
+	resp, err := middleware.PostUrl(pars, url, headers)
 	if err != nil {
 		logger.Error("请求异常")
-		return
+		return 2301, errors.New("提交请求异常")
 	}
 	res := response.CsrRes{}
 	if err = json.Unmarshal(resp, &res); err != nil {
 		logger.Error(string(resp), " json解析异常")
-		return
+		return 2301, errors.New("提交结果解析异常")
 	}
+	if res.Code != 200 {
+		logger.Error("提交失败 ", res.Message)
+		return res.Code, errors.New(res.Message)
+	}
+	return
 }
@@ -0,0 +1,88 @@
+package public
+
+import (
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 识别规则库
+type RepoRes struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    RepoQueryRes `json:"data"`
+}
+
+type RepoQueryRes struct {
+	List []RepoInfo `json:"list"`
+}
+
+type RepoInfo struct {
+	RepoId       int             `json:"repo_id"`
+	Name         string          `json:"name"`
+	Desc         string          `json:"description"`
+	ParserMsg    RepoParserInfo  `json:"parser_msg"`
+	DimensionMsg []DimensionInfo `json:"dimension_msg"`
+	AttrMsg      []AttrInfo      `json:"attr_msg"`
+}
+
+type RepoParserInfo struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type DimensionInfo struct {
+	DimensionId int    `json:"dimension_id"`
+	Name        string `json:"name"`
+}
+
+// AttrInfo 规则库属性，Name即规则体的key；上游没有必填标记，不校验必填
+type AttrInfo struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetRepos 查询识别规则库，repoIds为空时查询全部
+func GetRepos(token string, repoIds []int) (resData RepoQueryRes, code int, err error) {
+	// 1.整理入参
+	pars := map[string]interface{}{"type": 1}
+	if len(repoIds) != 0 {
+		pars["repo_ids"] = repoIds
+	}
+	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/query", middleware.GetAddr("repo-service"))
+	headers := map[string]string{"X-Access-Token": token}
+	// 2.获取数据
+	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	res := RepoRes{}
+	err = json.Unmarshal(respRepo, &res)
+	if err != nil {
+		logger.Info("数据查询失败", string(respRepo), pars)
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败")
+		return resData, res.Code, errors.New(res.Message)
+	}
+	resData = res.Data
+	return
+}
+
+// GetRepo 查询单个识别规则库
+func GetRepo(token string, repoId int) (resData RepoInfo, code int, err error) {
+	repoDataList, code, err := GetRepos(token, []int{repoId})
+	if err != nil {
+		return
+	}
+	for _, repo := range repoDataList.List {
+		if repo.RepoId == repoId {
+			return repo, 0, nil
+		}
+	}
+	return resData, 2301, fmt.Errorf("规则库[%d]不存在", repoId)
+}
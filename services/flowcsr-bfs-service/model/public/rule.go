@@ -0,0 +1,217 @@
+package public
+
+import (
+	"bigrule/common/logger"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// 识别规则（完整信息）
+type RuleRes struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    RuleListRes `json:"data"`
+}
+
+type RuleListRes struct {
+	List []RuleInfo `json:"list"`
+}
+
+type RuleInfo struct {
+	RuleId        int                   `json:"rule_id"`
+	LineNum       int                   `json:"line_num"`
+	Pattern       map[string]string     `json:"pattern"`
+	ParserMessage RuleParser            `json:"parser_message"`
+	Attributes    response.AttributeRes `json:"attributes"`
+	Dimensions    []RuleDimension       `json:"dimensions"`
+}
+
+type RuleDimension struct {
+	TagId         int    `json:"tag_id"`
+	TagName       string `json:"tag_name"`
+	DimensionId   int    `json:"dimension_id"`
+	DimensionName string `json:"dimension_name"`
+	TagValTblId   int    `json:"tagval_tbl_id"`
+}
+
+type RuleParser struct {
+	ParserId    int    `json:"parser_id"`
+	Description string `json:"description"`
+}
+
+// 规则新增入参，与 /rule-repo/rule/batchadd 的 messages 一致
+type RuleMessage struct {
+	Id         int                `json:"id"`
+	Attr       response.Attribute `json:"attr"`
+	Pattern    map[string]string  `json:"pattern"`
+	Dimensions []RuleDimensionAdd `json:"dimensions"`
+}
+
+type RuleDimensionAdd struct {
+	DimensionId int `json:"dimension_id"`
+	ValueId     int `json:"value_id"`
+}
+
+// Attribute 属性转换为数值类型
+func (r RuleInfo) Attribute() response.Attribute {
+	statusInt, _ := strconv.Atoi(r.Attributes.Status)
+	priorityInt, _ := strconv.Atoi(r.Attributes.Priority)
+	return response.Attribute{Status: statusInt, Priority: priorityInt, Desc: r.Attributes.Desc, Sample: r.Attributes.Sample}
+}
+
+// Message 规则快照转换为新增入参，用于补偿和复制
+func (r RuleInfo) Message() RuleMessage {
+	msg := RuleMessage{Id: r.RuleId, Attr: r.Attribute(), Pattern: r.Pattern, Dimensions: []RuleDimensionAdd{}}
+	for _, dim := range r.Dimensions {
+		msg.Dimensions = append(msg.Dimensions, RuleDimensionAdd{DimensionId: dim.DimensionId, ValueId: dim.TagId})
+	}
+	return msg
+}
+
+// Info 新增入参转换为规则信息，用于写入前在本地匹配
+func (m RuleMessage) Info() RuleInfo {
+	info := RuleInfo{
+		RuleId: m.Id, Pattern: m.Pattern, Dimensions: []RuleDimension{},
+		Attributes: response.AttributeRes{
+			Priority: strconv.Itoa(m.Attr.Priority), Status: strconv.Itoa(m.Attr.Status), Sample: m.Attr.Sample, Desc: m.Attr.Desc,
+		},
+	}
+	for _, dim := range m.Dimensions {
+		info.Dimensions = append(info.Dimensions, RuleDimension{DimensionId: dim.DimensionId, TagId: dim.ValueId})
+	}
+	return info
+}
+
+// GetRules 按规则id查询规则完整信息，不指定data_type时返回属性、规则体、维度及行号；ruleIds为空时不查询
+func GetRules(token string, repoId int, ruleIds []int) (resData RuleListRes, code int, err error) {
+	if len(ruleIds) == 0 {
+		resData.List = []RuleInfo{}
+		return
+	}
+	return queryRules(token, map[string]interface{}{"repo_id": repoId, "type": 1, "rule_ids": ruleIds})
+}
+
+// GetRepoRules 查询规则库全部规则的完整信息
+func GetRepoRules(token string, repoId int) (resData RuleListRes, code int, err error) {
+	return queryRules(token, map[string]interface{}{"repo_id": repoId, "type": 1})
+}
+
+// 规则库规则数
+type RuleCountRes struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    []struct {
+		RepoId     int `json:"repo_id"`
+		RuleNumber int `json:"rule_number"`
+	} `json:"data"`
+}
+
+// CountRules 规则库规则行数，包含当前token暂存的修改
+func CountRules(token string, repoId int) (count int, code int, err error) {
+	pars := map[string]interface{}{"repo_ids": []int{repoId}}
+	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/count", middleware.GetAddr("repo-service"))
+	headers := map[string]string{"X-Access-Token": token}
+	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return 0, 2301, errors.New("数据查询失败")
+	}
+	res := RuleCountRes{}
+	if err = json.Unmarshal(respRepo, &res); err != nil {
+		logger.Info("数据查询失败", string(respRepo), pars)
+		return 0, 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败")
+		return 0, res.Code, errors.New(res.Message)
+	}
+	for _, data := range res.Data {
+		if data.RepoId == repoId {
+			return data.RuleNumber, 0, nil
+		}
+	}
+	return 0, 2301, fmt.Errorf("规则库[%d]不存在", repoId)
+}
+
+func queryRules(token string, pars map[string]interface{}) (resData RuleListRes, code int, err error) {
+	urlRepo := fmt.Sprintf("http://%s/v1/rule-repo/rule/query", middleware.GetAddr("repo-service"))
+	headers := map[string]string{"X-Access-Token": token}
+	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	res := RuleRes{}
+	err = json.Unmarshal(respRepo, &res)
+	if err != nil {
+		logger.Info("数据查询失败", string(respRepo), pars)
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败")
+		return resData, res.Code, errors.New(res.Message)
+	}
+	resData = res.Data
+	return
+}
+
+// 解析规则（原始信息）
+type ParserRes struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Data    ParserListRes `json:"data"`
+}
+
+type ParserListRes struct {
+	List []json.RawMessage `json:"list"`
+}
+
+type ParserInfo struct {
+	ParserId     int                    `json:"parser_id"`
+	LineNum      int                    `json:"line_num"`
+	ParserRepoId int                    `json:"parser_repo_id"`
+	Raw          map[string]interface{} `json:"-"`
+}
+
+// GetParsers 查询解析规则，Raw保留原始信息，用于删除前留存快照；parserId为0时查询整库
+func GetParsers(token string, parserRepoId, parserId int) (resData []ParserInfo, code int, err error) {
+	// 1.整理入参
+	pars := map[string]interface{}{"parser_repo_id": parserRepoId, "type": 1}
+	if parserId != 0 {
+		pars["parser_id"] = parserId
+	}
+	urlRepo := fmt.Sprintf("http://%s/v1/parser-repo/parser/query", middleware.GetAddr("repo-service"))
+	headers := map[string]string{"X-Access-Token": token}
+	// 2.获取数据
+	respRepo, err := middleware.PostUrl(pars, urlRepo, headers)
+	if err != nil {
+		logger.Info("数据查询失败")
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	res := ParserRes{}
+	err = json.Unmarshal(respRepo, &res)
+	if err != nil {
+		logger.Info("数据查询失败", string(respRepo), pars)
+		return resData, 2301, errors.New("数据查询失败")
+	}
+	if res.Code != 200 {
+		logger.Info("数据查询失败")
+		return resData, res.Code, errors.New(res.Message)
+	}
+	// 3.整理
+	for _, raw := range res.Data.List {
+		parser := ParserInfo{}
+		if err = json.Unmarshal(raw, &parser); err != nil {
+			return resData, 2301, errors.New("数据查询失败")
+		}
+		if err = json.Unmarshal(raw, &parser.Raw); err != nil {
+			return resData, 2301, errors.New("数据查询失败")
+		}
+		resData = append(resData, parser)
+	}
+	return
+}
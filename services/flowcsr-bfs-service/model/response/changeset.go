@@ -0,0 +1,98 @@
+package response
+
+import (
+	"bigrule/pkg/utils"
+	"fmt"
+	"strings"
+)
+
+// 变更对象类型
+const (
+	ChangeRule   = "rule"
+	ChangeTag    = "tag"
+	ChangeParser = "parser"
+)
+
+// ChangeSet 一次写操作的结构化变更，随提交发送并返回给调用方
+type ChangeSet struct {
+	Operation string       `json:"operation"`
+	Added     []ChangeItem `json:"added"`
+	Deleted   []ChangeItem `json:"deleted"`
+	Updated   []ChangeItem `json:"updated"`
+}
+
+// ChangeItem RepoId 对规则为规则库id，对标签为标签表id，对解析规则为解析规则库id
+type ChangeItem struct {
+	Type    string   `json:"type"`
+	RepoId  int      `json:"repo_id"`
+	Id      int      `json:"id"`
+	LineNum int      `json:"line_num,omitempty"`
+	Fields  []string `json:"fields,omitempty"` // 修改的字段
+}
+
+// 写操作结果
+type WriteRes struct {
+	Message   string    `json:"message"`
+	ChangeSet ChangeSet `json:"change_set"`
+}
+
+func NewChangeSet(operation string) *ChangeSet {
+	return &ChangeSet{Operation: operation, Added: []ChangeItem{}, Deleted: []ChangeItem{}, Updated: []ChangeItem{}}
+}
+
+func (cs *ChangeSet) Add(item ChangeItem) {
+	cs.Added = append(cs.Added, item)
+}
+
+func (cs *ChangeSet) Delete(item ChangeItem) {
+	cs.Deleted = append(cs.Deleted, item)
+}
+
+// Update 同一对象多次修改合并字段
+func (cs *ChangeSet) Update(item ChangeItem) {
+	for i, updated := range cs.Updated {
+		if updated.Type == item.Type && updated.RepoId == item.RepoId && updated.Id == item.Id {
+			for _, field := range item.Fields {
+				if !utils.IsContainsStr(updated.Fields, field) {
+					cs.Updated[i].Fields = append(cs.Updated[i].Fields, field)
+				}
+			}
+			return
+		}
+	}
+	cs.Updated = append(cs.Updated, item)
+}
+
+func (cs *ChangeSet) IsEmpty() bool {
+	return len(cs.Added) == 0 && len(cs.Deleted) == 0 && len(cs.Updated) == 0
+}
+
+// Ids 指定操作、类型的对象id
+func (cs *ChangeSet) Ids(items []ChangeItem, itemType string) (ids []int) {
+	for _, item := range items {
+		if item.Type == itemType {
+			ids = append(ids, item.Id)
+		}
+	}
+	return
+}
+
+// Summary 可读的提交信息，如：标签删除： 删除规则：[12 13] 删除标签：[5]
+func (cs *ChangeSet) Summary() string {
+	names := map[string]string{ChangeRule: "规则", ChangeTag: "标签", ChangeParser: "解析规则"}
+	ops := []struct {
+		name  string
+		items []ChangeItem
+	}{{"增加", cs.Added}, {"删除", cs.Deleted}, {"修改", cs.Updated}}
+	summary := cs.Operation + "："
+	for _, op := range ops {
+		for _, itemType := range []string{ChangeRule, ChangeParser, ChangeTag} {
+			ids := cs.Ids(op.items, itemType)
+			if len(ids) == 0 {
+				continue
+			}
+			summary += fmt.Sprintf(" %s%s：%s", op.name, names[itemType], strings.Replace(fmt.Sprint(ids), " ", ",", -1))
+		}
+	}
+	return summary
+}
@@ -0,0 +1,53 @@
+package response
+
+// ItemResult 部分成功模式下单个对象的结果，Code为上游返回码，成功时为200
+type ItemResult struct {
+	Type    string `json:"type"`
+	Id      int    `json:"id"`
+	Success bool   `json:"success"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// PartialRes 部分成功模式的写操作结果，每个对象单独提交，ChangeSet为成功对象的变更合集
+type PartialRes struct {
+	Message   string       `json:"message"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Items     []ItemResult `json:"items"`
+	ChangeSet ChangeSet    `json:"change_set"`
+}
+
+func NewPartialRes(operation string) *PartialRes {
+	return &PartialRes{Items: []ItemResult{}, ChangeSet: *NewChangeSet(operation)}
+}
+
+// Succeed 记录成功对象并合入其变更
+func (r *PartialRes) Succeed(itemType string, id int, cs *ChangeSet) {
+	r.Succeeded++
+	r.Items = append(r.Items, ItemResult{Type: itemType, Id: id, Success: true, Code: 200, Message: "成功"})
+	r.ChangeSet.Added = append(r.ChangeSet.Added, cs.Added...)
+	r.ChangeSet.Deleted = append(r.ChangeSet.Deleted, cs.Deleted...)
+	for _, item := range cs.Updated {
+		r.ChangeSet.Update(item)
+	}
+}
+
+// Fail 记录失败对象
+func (r *PartialRes) Fail(itemType string, id, code int, message string) {
+	r.Failed++
+	r.Items = append(r.Items, ItemResult{Type: itemType, Id: id, Code: code, Message: message})
+}
+
+// Finish 按成功、失败数量生成结果信息
+func (r *PartialRes) Finish() *PartialRes {
+	switch {
+	case r.Failed == 0:
+		r.Message = "全部成功"
+	case r.Succeeded == 0:
+		r.Message = "全部失败"
+	default:
+		r.Message = "部分成功"
+	}
+	return r
+}
@@ -0,0 +1,50 @@
+package response
+
+// 删除预览，dry_run时返回，不执行任何修改
+type Plan struct {
+	UnlinkRules   []PlanUnlinkRule   `json:"unlink_rules"`
+	DeleteRules   []PlanRule         `json:"delete_rules"`
+	UnlinkParsers []PlanUnlinkParser `json:"unlink_parsers"`
+	DeleteParsers []PlanParser       `json:"delete_parsers"`
+	DeleteTags    []PlanTag          `json:"delete_tags"`
+}
+
+type PlanUnlinkRule struct {
+	RepoId      int `json:"repo_id"`
+	RuleId      int `json:"rule_id"`
+	DimensionId int `json:"dimension_id"`
+	TagId       int `json:"tag_id"`
+}
+
+type PlanRule struct {
+	RepoId  int `json:"repo_id"`
+	RuleId  int `json:"rule_id"`
+	LineNum int `json:"line_num"`
+}
+
+type PlanUnlinkParser struct {
+	RepoId       int `json:"repo_id"`
+	RuleId       int `json:"rule_id"`
+	ParserRepoId int `json:"parser_repo_id"`
+	ParserId     int `json:"parser_id"`
+}
+
+type PlanParser struct {
+	ParserRepoId int `json:"parser_repo_id"`
+	ParserId     int `json:"parser_id"`
+	LineNum      int `json:"line_num"`
+}
+
+type PlanTag struct {
+	TagValTblId int    `json:"tagval_tbl_id"`
+	TagId       int    `json:"tag_id"`
+	TagName     string `json:"tag_name"`
+	LineNum     int    `json:"line_num"`
+}
+
+func NewPlan() Plan {
+	return Plan{
+		UnlinkRules: []PlanUnlinkRule{}, DeleteRules: []PlanRule{}, UnlinkParsers: []PlanUnlinkParser{},
+		DeleteParsers: []PlanParser{}, DeleteTags: []PlanTag{},
+	}
+}
@@ -0,0 +1,117 @@
+package ruleid
+
+import (
+	"bigrule/common/global"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	DefaultTTL = 30 * time.Minute
+	MaxCount   = 1000
+	retries    = 3
+)
+
+// Reservation 规则id预留，同一规则库内唯一，过期后释放；Owner为预留方，只有预留方可以使用
+type Reservation struct {
+	Id        int       `json:"id"          gorm:"primaryKey;autoIncrement"`
+	RepoId    int       `json:"repo_id"     gorm:"uniqueIndex:idx_repo_rule"`
+	RuleId    int       `json:"rule_id"     gorm:"uniqueIndex:idx_repo_rule"`
+	Owner     string    `json:"-"           gorm:"size:64"`
+	UserName  string    `json:"user_name"   gorm:"size:64"`
+	ExpiresAt time.Time `json:"expires_at"  gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Reservation) TableName() string {
+	return "bfs_rule_id_reservation"
+}
+
+// Migrate 建表
+func Migrate() error {
+	return global.DBMysql.AutoMigrate(&Reservation{})
+}
+
+// Reserve 在已有规则id及未过期预留之后预留count个连续id，并发冲突时重试
+func Reserve(repoId int, existing []int, count int, owner, userName string, ttl time.Duration) (ids []int, expiresAt time.Time, err error) {
+	if count <= 0 || count > MaxCount {
+		return nil, expiresAt, errors.New("预留数量超出范围")
+	}
+	if err = global.DBMysql.Where("expires_at < ?", time.Now()).Delete(&Reservation{}).Error; err != nil {
+		return
+	}
+	expiresAt = time.Now().Add(ttl)
+	for i := 0; i < retries; i++ {
+		ids, err = reserve(repoId, existing, count, owner, userName, expiresAt)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func reserve(repoId int, existing []int, count int, owner, userName string, expiresAt time.Time) (ids []int, err error) {
+	err = global.DBMysql.Transaction(func(tx *gorm.DB) error {
+		maxId := 0
+		for _, id := range existing {
+			if id > maxId {
+				maxId = id
+			}
+		}
+		reserved := 0
+		if err := tx.Model(&Reservation{}).Where("repo_id = ?", repoId).Select("COALESCE(MAX(rule_id), 0)").Scan(&reserved).Error; err != nil {
+			return err
+		}
+		if reserved > maxId {
+			maxId = reserved
+		}
+		list := []Reservation{}
+		for i := 1; i <= count; i++ {
+			list = append(list, Reservation{RepoId: repoId, RuleId: maxId + i, Owner: owner, UserName: userName, ExpiresAt: expiresAt})
+		}
+		if err := tx.Create(&list).Error; err != nil {
+			return err
+		}
+		ids = []int{}
+		for _, r := range list {
+			ids = append(ids, r.RuleId)
+		}
+		return nil
+	})
+	return
+}
+
+// MaxReserved 规则库中未过期预留的最大id，没有时为0
+func MaxReserved(repoId int) (maxId int, err error) {
+	err = global.DBMysql.Model(&Reservation{}).Where("repo_id = ? AND expires_at >= ?", repoId, time.Now()).
+		Select("COALESCE(MAX(rule_id), 0)").Scan(&maxId).Error
+	return
+}
+
+// Others 规则库中其他预留方未过期的预留id
+func Others(repoId int, owner string) (ids []int, err error) {
+	ids = []int{}
+	err = global.DBMysql.Model(&Reservation{}).Where("repo_id = ? AND owner <> ? AND expires_at >= ?", repoId, owner, time.Now()).Pluck("rule_id", &ids).Error
+	return
+}
+
+// ReservedByOthers ruleIds中被其他预留方预留且未过期的id
+func ReservedByOthers(repoId int, ruleIds []int, owner string) (ids []int, err error) {
+	ids = []int{}
+	if len(ruleIds) == 0 {
+		return
+	}
+	err = global.DBMysql.Model(&Reservation{}).
+		Where("repo_id = ? AND rule_id IN ? AND owner <> ? AND expires_at >= ?", repoId, ruleIds, owner, time.Now()).
+		Pluck("rule_id", &ids).Error
+	return
+}
+
+// Release 规则写入后释放预留
+func Release(repoId int, ruleIds []int) error {
+	if len(ruleIds) == 0 {
+		return nil
+	}
+	return global.DBMysql.Where("repo_id = ? AND rule_id IN ?", repoId, ruleIds).Delete(&Reservation{}).Error
+}
@@ -0,0 +1,137 @@
+package schedule
+
+import (
+	"bigrule/common/global"
+	"bigrule/common/logger"
+	"bigrule/pkg/utils"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// 到期检查间隔
+const interval = 30 * time.Second
+
+// 执行结果保存的最大长度
+const maxResult = 4096
+
+// Run 定时执行到期的操作，直到ctx取消
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue 按执行时间顺序执行到期的操作，执行前将超时的执行中记录标记为失败
+func runDue(now time.Time) {
+	if err := expire(now); err != nil {
+		logger.Error("定时操作状态重置失败 ", err.Error())
+	}
+	list := []Schedule{}
+	err := global.DBMysql.Where("state = ? AND execute_at <= ?", StatePending, now).Order("execute_at, id").Find(&list).Error
+	if err != nil {
+		logger.Error("定时操作查询失败 ", err.Error())
+		return
+	}
+	if len(list) == 0 {
+		return
+	}
+	// 创建人权限校验通过后以服务账号执行，服务账号登录失败时使用创建人token
+	serviceToken, _, err := middleware.GetUser()
+	if err != nil {
+		logger.Error("服务账号登录失败 ", err.Error())
+	}
+	for _, s := range list {
+		if !claim(s.Id) {
+			continue
+		}
+		creatorToken, code, err := s.authorize()
+		if err != nil {
+			s.finish(StateFailed, code, err.Error())
+			continue
+		}
+		token := creatorToken
+		if serviceToken != "" {
+			token = serviceToken
+		}
+		s.execute(token)
+	}
+}
+
+// authorize 执行时重新校验创建人对规则库的权限，创建人token失效或已无权限时不执行
+func (s Schedule) authorize() (token string, code int, err error) {
+	if token, err = middleware.Unseal(s.CreatorToken); err != nil {
+		return token, 0, fmt.Errorf("创建人%s", err.Error())
+	}
+	permission, code, err := middleware.GetPermission(token)
+	if err != nil {
+		return token, code, fmt.Errorf("创建人权限校验失败：%s", err.Error())
+	}
+	if !utils.IsContainsInt(permission.RepoIds, s.RepoId) {
+		return token, 2007, fmt.Errorf("创建人%s已无规则库[%d]的权限", s.UserName, s.RepoId)
+	}
+	return
+}
+
+// execute 与接口调用相同，经过写操作队列、审计及提交；提交信息及审计中记录创建人
+func (s Schedule) execute(token string) {
+	path := fmt.Sprintf("/%s/rules/%s", global.Version, s.Action)
+	pars := map[string]interface{}{"repo_id": s.RepoId, "rule_ids": s.Ids()}
+	switch s.Action {
+	case ActionStatus:
+		pars["status"] = s.Status
+	case ActionDelete:
+		pars["tag_op"] = s.TagOp
+	}
+	body, _ := json.Marshal(pars)
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		s.finish(StateFailed, 0, err.Error())
+		return
+	}
+	req = req.WithContext(middleware.WithScheduleCreator(req.Context(), middleware.Caller{UserId: s.UserId, UserName: s.UserName}))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", token)
+	w := httptest.NewRecorder()
+	global.GinEngine.ServeHTTP(w, req)
+	res := struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{}
+	result := w.Body.String()
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		s.finish(StateFailed, w.Code, result)
+		return
+	}
+	switch res.Code {
+	case 200:
+		s.finish(StateDone, res.Code, result)
+	case 503:
+		// 服务关闭中，重启后再执行
+		s.finish(StatePending, res.Code, result)
+	default:
+		s.finish(StateFailed, res.Code, result)
+	}
+}
+
+func (s Schedule) finish(state string, code int, result string) {
+	if r := []rune(result); len(r) > maxResult {
+		result = string(r[:maxResult])
+	}
+	logger.Info(fmt.Sprintf("定时操作[%d]%s，状态：%s", s.Id, s.Action, state))
+	if err := finish(s.Id, state, code, result); err != nil {
+		logger.Error(fmt.Sprintf("定时操作[%d]状态保存失败 %s", s.Id, err.Error()))
+	}
+}
@@ -0,0 +1,151 @@
+package schedule
+
+import (
+	"bigrule/common/global"
+	"bigrule/services/flowcsr-bfs-service/middleware"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// 定时操作
+const (
+	ActionStatus = "status"
+	ActionDelete = "delete"
+)
+
+// 执行状态
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// 执行中的记录超过该时间未结束视为执行实例已退出
+const lease = 10 * time.Minute
+
+// Schedule 定时修改规则状态或删除规则，到期后由后台按正常写操作执行；
+// CreatorToken为加密后的创建人token，执行前用于校验创建人权限
+type Schedule struct {
+	Id           int       `json:"id"           gorm:"primaryKey;autoIncrement"`
+	RepoId       int       `json:"repo_id"      gorm:"index"`
+	RuleIds      string    `json:"-"            gorm:"type:text"` // json数组
+	Action       string    `json:"action"       gorm:"size:16"`
+	Status       int       `json:"status"` // 目标状态，Action为status时有效
+	TagOp        int       `json:"tag_op"` // 同规则删除，Action为delete时有效
+	ExecuteAt    time.Time `json:"execute_at"   gorm:"index"`
+	State        string    `json:"state"        gorm:"size:16;index"`
+	Code         int       `json:"code"`
+	Result       string    `json:"result"       gorm:"type:text"`
+	CreatorToken string    `json:"-"            gorm:"type:text"`
+	UserId       int       `json:"user_id"`
+	UserName     string    `json:"user_name"    gorm:"size:64"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (Schedule) TableName() string {
+	return "bfs_rule_schedule"
+}
+
+// Migrate 建表，删除旧版本保存token原文的列
+func Migrate() error {
+	if err := global.DBMysql.AutoMigrate(&Schedule{}); err != nil {
+		return err
+	}
+	if migrator := global.DBMysql.Migrator(); migrator.HasColumn(&Schedule{}, "token") {
+		return migrator.DropColumn(&Schedule{}, "token")
+	}
+	return nil
+}
+
+// Ids 定时操作的规则id
+func (s Schedule) Ids() (ruleIds []int) {
+	_ = json.Unmarshal([]byte(s.RuleIds), &ruleIds)
+	return
+}
+
+// MarshalJSON 规则id以数组返回
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	type plain Schedule
+	return json.Marshal(struct {
+		plain
+		RuleIds []int `json:"rule_ids"`
+	}{plain(s), s.Ids()})
+}
+
+// Create 新增待执行的定时操作，创建人token加密保存
+func Create(s *Schedule, ruleIds []int, token string) error {
+	b, err := json.Marshal(ruleIds)
+	if err != nil {
+		return err
+	}
+	s.RuleIds = string(b)
+	if s.CreatorToken, err = middleware.Seal(token); err != nil {
+		return err
+	}
+	s.State = StatePending
+	return global.DBMysql.Create(s).Error
+}
+
+// Filter 定时操作查询条件，零值不过滤
+type Filter struct {
+	RepoIds   []int
+	State     string
+	PageSize  int
+	PageIndex int
+}
+
+// Query 按执行时间倒序
+func Query(filter Filter) (list []Schedule, total int64, err error) {
+	db := global.DBMysql.Model(&Schedule{})
+	if len(filter.RepoIds) != 0 {
+		db = db.Where("repo_id IN ?", filter.RepoIds)
+	}
+	if filter.State != "" {
+		db = db.Where("state = ?", filter.State)
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("execute_at DESC, id DESC").Offset((filter.PageIndex - 1) * filter.PageSize).Limit(filter.PageSize).Find(&list).Error
+	return
+}
+
+// Get 按id查询
+func Get(id int) (s Schedule, err error) {
+	err = global.DBMysql.First(&s, id).Error
+	return
+}
+
+// Cancel 取消待执行的定时操作，已开始执行的不能取消
+func Cancel(id int) error {
+	res := global.DBMysql.Model(&Schedule{}).Where("id = ? AND state = ?", id, StatePending).Update("state", StateCancelled)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("定时操作不存在或已执行")
+	}
+	return nil
+}
+
+// claim 待执行改为执行中，多个实例同时执行时只有一个成功
+func claim(id int) bool {
+	res := global.DBMysql.Model(&Schedule{}).Where("id = ? AND state = ?", id, StatePending).
+		Updates(map[string]interface{}{"state": StateRunning, "updated_at": time.Now()})
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// expire 执行中超过lease的记录结果未知，标记为失败；其他实例正在执行的记录不受影响
+func expire(now time.Time) error {
+	return global.DBMysql.Model(&Schedule{}).Where("state = ? AND updated_at < ?", StateRunning, now.Add(-lease)).
+		Updates(map[string]interface{}{"state": StateFailed, "result": "执行超时，执行结果未知"}).Error
+}
+
+func finish(id int, state string, code int, result string) error {
+	return global.DBMysql.Model(&Schedule{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"state": state, "code": code, "result": result}).Error
+}
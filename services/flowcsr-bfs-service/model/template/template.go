@@ -0,0 +1,120 @@
+package template
+
+import (
+	"bigrule/common/global"
+	"bigrule/services/flowcsr-bfs-service/model/response"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// ErrDuplicateName 同一规则库下模板名称重复
+var ErrDuplicateName = errors.New("该规则库下已存在同名模板")
+
+// Template 规则模板，Spec为TemplateSpec的json，名称在规则库内唯一
+type Template struct {
+	Id        int       `json:"id"          gorm:"primaryKey;autoIncrement"`
+	Name      string    `json:"name"        gorm:"size:64;uniqueIndex:idx_repo_name,priority:2"`
+	RepoId    int       `json:"repo_id"     gorm:"uniqueIndex:idx_repo_name,priority:1"`
+	Desc      string    `json:"desc"        gorm:"size:255"`
+	Spec      string    `json:"-"           gorm:"type:text"`
+	UserName  string    `json:"user_name"   gorm:"size:64"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Template) TableName() string {
+	return "bfs_rule_template"
+}
+
+// Spec 模板内容，Pattern及Attr中的Sample、Desc可包含{{变量名}}占位符
+type Spec struct {
+	Pattern    map[string]string  `json:"pattern"`
+	Attr       response.Attribute `json:"attr"`
+	Dimensions []Binding          `json:"dimensions"`
+	Quote      bool               `json:"quote"` // 为true时代入规则体的变量按字面量转义
+}
+
+// Binding 维度与标签的绑定，ValueId为固定标签；IdVar不为空时标签id取自变量，NameVar不为空时按变量新增标签
+type Binding struct {
+	DimensionId int    `json:"dimension_id"`
+	ValueId     int    `json:"value_id"`
+	IdVar       string `json:"id_var"`
+	NameVar     string `json:"name_var"`
+}
+
+// Migrate 建表，名称唯一索引由全局改为规则库内
+func Migrate() error {
+	if err := global.DBMysql.AutoMigrate(&Template{}); err != nil {
+		return err
+	}
+	if migrator := global.DBMysql.Migrator(); migrator.HasIndex(&Template{}, "idx_bfs_rule_template_name") {
+		return migrator.DropIndex(&Template{}, "idx_bfs_rule_template_name")
+	}
+	return nil
+}
+
+// Decode 解析模板内容
+func (t Template) Decode() (spec Spec, err error) {
+	err = json.Unmarshal([]byte(t.Spec), &spec)
+	return
+}
+
+// Save Id为0时新增，否则修改
+func Save(t *Template, spec Spec) error {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	t.Spec = string(b)
+	var count int64
+	if err := global.DBMysql.Model(&Template{}).Where("repo_id = ? AND name = ? AND id <> ?", t.RepoId, t.Name, t.Id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count != 0 {
+		return ErrDuplicateName
+	}
+	if t.Id == 0 {
+		return duplicateName(global.DBMysql.Create(t).Error)
+	}
+	old, err := Get(t.Id)
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = old.CreatedAt
+	return duplicateName(global.DBMysql.Save(t).Error)
+}
+
+// duplicateName 并发保存时由唯一索引兜底，mysql返回1062
+func duplicateName(err error) error {
+	if err != nil && strings.Contains(err.Error(), "Error 1062") {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// Get 按id查询模板
+func Get(id int) (t Template, err error) {
+	err = global.DBMysql.First(&t, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = errors.New("模板不存在")
+	}
+	return
+}
+
+// List 规则库下的模板，repoIds为空时查询全部
+func List(repoIds []int) (list []Template, err error) {
+	db := global.DBMysql.Model(&Template{})
+	if len(repoIds) != 0 {
+		db = db.Where("repo_id IN ?", repoIds)
+	}
+	err = db.Order("id").Find(&list).Error
+	return
+}
+
+// Delete 删除模板
+func Delete(id int) error {
+	return global.DBMysql.Delete(&Template{}, id).Error
+}
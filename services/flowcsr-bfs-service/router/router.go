@@ -2,6 +2,7 @@ package router
 
 import (
 	"bigrule/common/router"
+	"bigrule/services/flowcsr-bfs-service/controller/audits"
 	"bigrule/services/flowcsr-bfs-service/controller/parsers"
 	"bigrule/services/flowcsr-bfs-service/controller/ping"
 	"bigrule/services/flowcsr-bfs-service/controller/repos"
@@ -16,5 +17,6 @@ func RouterSetup() {
 		tags.TagRouter{},
 		parsers.ParserRouter{},
 		repos.RepoRouter{},
+		audits.AuditRouter{},
 	)
 }